@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"snippetbox.dkimhw.com/internal/cli"
+)
+
+// The snippet command is a command-line client for the snippetbox JSON API,
+// for example:
+//
+//	some-cmd | snippet create -t "Output of some-cmd" -expires 7
+//	snippet get 42 > file.txt
+func main() {
+	// Cancel any in-flight request if the user presses Ctrl+C.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(cli.Run(ctx, os.Args[1:], cli.OSEnv()))
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+// The handlers in this file make up the JSON API under /api/v1. They use the
+// same models and validation rules as the HTML handlers, but every response
+// (including errors) is a JSON object.
+
+// envelope wraps the data in every API response, so that the top level of the
+// JSON is always an object with a descriptive key, e.g. {"snippet": {...}}.
+type envelope map[string]any
+
+// The apiError type is the body of every API error response. Validation
+// failures also carry the per-field messages from validator.Validator.
+type apiError struct {
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+type paginationMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+func newPaginationMetadata(page, pageSize, total int) paginationMetadata {
+	if total == 0 {
+		return paginationMetadata{CurrentPage: page, PageSize: pageSize}
+	}
+
+	return paginationMetadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (total + pageSize - 1) / pageSize,
+		TotalRecords: total,
+	}
+}
+
+// The snippetInput struct holds the request body for creating or updating a
+// snippet. Expires is the number of days until the snippet expires, and must
+// be one of the values permitted by the HTML form. A snippet's content is
+// given either as a single Content string or as a list of Files.
+type snippetInput struct {
+	Title               string        `json:"title"`
+	Content             string        `json:"content"`
+	Files               []models.File `json:"files"`
+	Expires             int           `json:"expires"`
+	Tags                []string      `json:"tags"` // only used when creating a snippet
+	validator.Validator `json:"-"`
+}
+
+// validate checks the input and returns the files for the snippet.
+func (input *snippetInput) validate() []models.File {
+	validateSnippet(&input.Validator, input.Title, input.Expires)
+
+	if input.Files == nil {
+		validateContent(&input.Validator, input.Content)
+		return normalizeFiles([]models.File{{Content: input.Content}})
+	}
+
+	input.CheckField(input.Content == "", "content", "Use either content or files, not both")
+
+	files := normalizeFiles(input.Files)
+	validateFiles(&input.Validator, files)
+	return files
+}
+
+var errUnsupportedMediaType = errors.New("Content-Type header must be application/json")
+
+// bodyTooLargeError is returned by readJSON() for bodies which are larger than
+// the limit. It wraps the *http.MaxBytesError, so that apiReadError() can
+// send a 413 response.
+type bodyTooLargeError struct {
+	*http.MaxBytesError
+}
+
+func (e bodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+func (e bodyTooLargeError) Unwrap() error {
+	return e.MaxBytesError
+}
+
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	var v validator.Validator
+
+	page := readInt(r, "page", 1, &v)
+	pageSize := readInt(r, "page_size", 20, &v)
+
+	v.CheckField(page >= 1 && page <= 10_000, "page", "This field must be between 1 and 10000")
+	v.CheckField(pageSize >= 1 && pageSize <= 100, "page_size", "This field must be between 1 and 100")
+
+	if !v.Valid() {
+		app.apiFailedValidation(w, r, v)
+		return
+	}
+
+	snippets, total, err := app.snippets.List(r.Context(), page, pageSize)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// Make sure that an empty page is encoded as [] rather than null.
+	if snippets == nil {
+		snippets = []models.Snippet{}
+	}
+
+	app.apiWriteJSON(w, r, http.StatusOK, envelope{
+		"snippets": snippets,
+		"metadata": newPaginationMetadata(page, pageSize, total),
+	})
+}
+
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiReadSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.apiWriteJSON(w, r, http.StatusOK, envelope{"snippet": snippet})
+}
+
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var input snippetInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiReadError(w, r, err)
+		return
+	}
+
+	input.Tags = normalizeTags(input.Tags)
+
+	files := input.validate()
+	validateTags(&input.Validator, input.Tags)
+	if !input.Valid() {
+		app.apiFailedValidation(w, r, input.Validator)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	id, err := app.snippets.Insert(r.Context(), userID, input.Title, files, input.Expires, input.Tags)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.notifySnippetEvent(r, models.EventSnippetCreated, snippet)
+
+	// Let the client know where to find the new snippet.
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%d", id))
+
+	app.apiWriteJSON(w, r, http.StatusCreated, envelope{"snippet": snippet})
+}
+
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiReadOwnSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var input snippetInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiReadError(w, r, err)
+		return
+	}
+
+	// The snippet's files are all replaced, so a request with just a content
+	// field turns a snippet with several files into one with a single file.
+	files := input.validate()
+	input.CheckField(input.Tags == nil, "tags", "Tags cannot be changed once a snippet has been created")
+	if !input.Valid() {
+		app.apiFailedValidation(w, r, input.Validator)
+		return
+	}
+
+	err = app.snippets.Update(r.Context(), snippet.ID, input.Title, files, input.Expires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	snippet, err = app.snippets.Get(r.Context(), snippet.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	app.notifySnippetEvent(r, models.EventSnippetUpdated, snippet)
+
+	app.apiWriteJSON(w, r, http.StatusOK, envelope{"snippet": snippet})
+}
+
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiReadOwnSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(r.Context(), snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	// The snippet is gone now, so the payload contains the copy we fetched
+	// before deleting it.
+	app.notifySnippetEvent(r, models.EventSnippetDeleted, snippet)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The apiTokenList handler lists the authenticated user's tokens. Like the
+// account page, it never includes the plain-text token values.
+func (app *application) apiTokenList(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.tokens.GetForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	if tokens == nil {
+		tokens = []models.Token{}
+	}
+
+	app.apiWriteJSON(w, r, http.StatusOK, envelope{"tokens": tokens})
+}
+
+func (app *application) apiTokenDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return
+	}
+
+	err = app.tokens.Delete(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The apiReadSnippet helper fetches the snippet identified by the {id} path
+// value. If that fails it sends the appropriate error response and returns
+// false.
+func (app *application) apiReadSnippet(w http.ResponseWriter, r *http.Request) (models.Snippet, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return models.Snippet{}, false
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return models.Snippet{}, false
+	}
+
+	return snippet, true
+}
+
+// The apiReadOwnSnippet helper is like apiReadSnippet, but also checks that
+// the snippet belongs to the authenticated user. Snippets created before
+// ownership was recorded can't be changed through the API.
+func (app *application) apiReadOwnSnippet(w http.ResponseWriter, r *http.Request) (models.Snippet, bool) {
+	snippet, ok := app.apiReadSnippet(w, r)
+	if !ok {
+		return models.Snippet{}, false
+	}
+
+	userID := app.authenticatedUserID(r)
+	if snippet.UserID == 0 || snippet.UserID != userID {
+		app.apiErrorResponse(w, r, http.StatusForbidden, apiError{Message: "you do not have permission to modify this snippet"})
+		return models.Snippet{}, false
+	}
+
+	return snippet, true
+}
+
+// The readJSON helper decodes a JSON request body into dst. Requests must be
+// sent with a JSON Content-Type header.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+
+	// Limit the size of the request body to 1MB.
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var (
+			syntaxError        *json.SyntaxError
+			unmarshalTypeError *json.UnmarshalTypeError
+			maxBytesError      *http.MaxBytesError
+		)
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+		case errors.As(err, &maxBytesError):
+			return bodyTooLargeError{maxBytesError}
+		default:
+			return err
+		}
+	}
+
+	// Make sure that the body only contained a single JSON value.
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// readInt reads an integer query string parameter, returning def if it is
+// missing. Unparseable values are recorded as a field error.
+func readInt(r *http.Request, key string, def int, v *validator.Validator) int {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddFieldError(key, "This field must be an integer")
+		return def
+	}
+
+	return i
+}
+
+// The apiWriteJSON helper sends a JSON response, falling back to a server
+// error if the data can't be encoded.
+func (app *application) apiWriteJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	err := app.writeJSON(w, status, data)
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiErrorResponse(w http.ResponseWriter, r *http.Request, status int, e apiError) {
+	err := app.writeJSON(w, status, envelope{"error": e})
+	if err != nil {
+		app.requestLogger(r).Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// The apiServerError helper logs the error and sends a generic 500 response.
+// As with the HTML pages, the request ID is included for support purposes.
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+
+	app.apiErrorResponse(w, r, http.StatusInternalServerError, apiError{
+		Message:   "the server encountered a problem and could not process your request",
+		RequestID: requestID(r),
+	})
+}
+
+func (app *application) apiInvalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.apiErrorResponse(w, r, http.StatusUnauthorized, apiError{Message: "invalid or expired authentication token"})
+}
+
+func (app *application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	app.apiErrorResponse(w, r, http.StatusNotFound, apiError{Message: "the requested resource could not be found"})
+}
+
+// The apiReadError helper sends the response for an error from readJSON().
+func (app *application) apiReadError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		status = http.StatusUnsupportedMediaType
+	case errors.As(err, &maxBytesError):
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	app.apiErrorResponse(w, r, status, apiError{Message: err.Error()})
+}
+
+func (app *application) apiFailedValidation(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	message := "the request contains invalid data"
+	if len(v.NoneFieldErrors) > 0 {
+		message = strings.Join(v.NoneFieldErrors, "; ")
+	}
+
+	app.apiErrorResponse(w, r, http.StatusUnprocessableEntity, apiError{Message: message, Fields: v.FieldErrors})
+}
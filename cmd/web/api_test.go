@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+// apiHeaders returns the headers for an API request authenticated with the
+// given token.
+func apiHeaders(token string) map[string]string {
+	return map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer " + token,
+	}
+}
+
+func TestAPISnippetList(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "First page",
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"metadata":{"current_page":1,"page_size":20,"first_page":1,"last_page":1,"total_records":1}`,
+		},
+		{
+			name:     "Empty page",
+			urlPath:  "/api/v1/snippets?page=2",
+			wantCode: http.StatusOK,
+			wantBody: `"snippets":[]`,
+		},
+		{
+			name:     "Invalid page size",
+			urlPath:  "/api/v1/snippets?page_size=1000",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"page_size":"This field must be between 1 and 100"`,
+		},
+		{
+			name:     "Non-integer page",
+			urlPath:  "/api/v1/snippets?page=one",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"page":"This field must be an integer"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Content-Type"), "application/json")
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/api/v1/snippets/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"title":"An old silent pond"`)
+
+	code, _, body = ts.get(t, "/api/v1/snippets/2")
+	assert.Equal(t, code, http.StatusNotFound)
+	assert.StringContains(t, body, `"error":{"message":"the requested resource could not be found"}`)
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const validBody = `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     validBody,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Unauthenticated",
+			headers:  map[string]string{"Content-Type": "application/json"},
+			body:     validBody,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Invalid token",
+			headers:  apiHeaders("sbx_WRONG"),
+			body:     validBody,
+			wantCode: http.StatusUnauthorized,
+			wantBody: `"message":"invalid or expired authentication token"`,
+		},
+		{
+			name:     "Read-only token",
+			headers:  apiHeaders(mocks.ReadToken),
+			body:     validBody,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Blank title and bad expiry",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"","content":"Climb Mount Fuji","expires":30}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"fields":{"expires":"This field must equal 1, 7 or 365","title":"This field cannot be blank"}`,
+		},
+		{
+			name:     "Multiple files",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","files":[{"name":"fuji.txt","content":"Climb Mount Fuji"},{"name":"main.go","content":"package main"}],"expires":7}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Content and files",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","content":"Climb","files":[{"name":"fuji.txt","content":"Climb Mount Fuji"}],"expires":7}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"content":"Use either content or files, not both"`,
+		},
+		{
+			name:     "Duplicate file names",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","files":[{"name":"a.txt","content":"Climb"},{"name":"A.txt","content":"Mount Fuji"}],"expires":7}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"files.1.name":"Another file already has this name"`,
+		},
+		{
+			name:     "Content too large",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","content":"` + strings.Repeat("a", maxFileSize+1) + `","expires":7}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"content":"This field cannot be more than 64KB"`,
+		},
+		{
+			name:     "File too large",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","files":[{"name":"fuji.txt","content":"` + strings.Repeat("a", maxFileSize+1) + `"}],"expires":7}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"files.0.content":"This field cannot be more than 64KB"`,
+		},
+		{
+			name:     "Body too large",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","content":"` + strings.Repeat("a", 1<<20) + `","expires":7}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: `body must not be larger than 1048576 bytes`,
+		},
+		{
+			name:     "Unknown field",
+			headers:  apiHeaders(mocks.WriteToken),
+			body:     `{"title":"O snail","content":"Climb Mount Fuji","expires":7,"author":"Issa"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `body contains unknown key \"author\"`,
+		},
+		{
+			name: "Form encoded body",
+			headers: map[string]string{
+				"Content-Type":  "application/x-www-form-urlencoded",
+				"Authorization": "Bearer " + mocks.WriteToken,
+			},
+			body:     `title=O+snail`,
+			wantCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/api/v1/snippets", tt.headers, tt.body)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAPISnippetDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.do(t, http.MethodDelete, "/api/v1/snippets/1", apiHeaders(mocks.WriteToken), "")
+	assert.Equal(t, code, http.StatusNoContent)
+
+	code, _, _ = ts.do(t, http.MethodDelete, "/api/v1/snippets/2", apiHeaders(mocks.WriteToken), "")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAPITokenList(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Managing tokens needs the admin scope.
+	code, _, _ := ts.do(t, http.MethodGet, "/api/v1/tokens", apiHeaders(mocks.WriteToken), "")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, body := ts.do(t, http.MethodGet, "/api/v1/tokens", apiHeaders(mocks.AdminToken), "")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"name":"admin","scope":"admin"`)
+}
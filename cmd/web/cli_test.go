@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/cli"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+// These tests run the snippet command-line client against the real
+// application routes, to check that the two stay compatible.
+func TestCLI(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Use an empty config file, so that the tests aren't affected by any
+	// config file on the machine running them.
+	config := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(config, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		token      string
+		stdin      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "Create",
+			args:       []string{"create", "-t", "A title", "-expires", "7"},
+			token:      mocks.WriteToken,
+			stdin:      "Some content",
+			wantCode:   0,
+			wantStdout: ts.URL + "/snippet/view/1\n",
+		},
+		{
+			name:       "Create with JSON output",
+			args:       []string{"create", "-t", "A title", "-format", "json"},
+			token:      mocks.WriteToken,
+			stdin:      "Some content",
+			wantCode:   0,
+			wantStdout: `"id": 1`,
+		},
+		{
+			name:       "Create with invalid expires",
+			args:       []string{"create", "-t", "A title", "-expires", "30"},
+			token:      mocks.WriteToken,
+			wantCode:   2,
+			wantStderr: "-expires must be 1, 7 or 365",
+		},
+		{
+			name:       "Create with empty title",
+			args:       []string{"create"},
+			token:      mocks.WriteToken,
+			stdin:      "Some content",
+			wantCode:   1,
+			wantStderr: "title: This field cannot be blank",
+		},
+		{
+			name:       "Create with read-only token",
+			args:       []string{"create", "-t", "A title"},
+			token:      mocks.ReadToken,
+			stdin:      "Some content",
+			wantCode:   1,
+			wantStderr: "(403)",
+		},
+		{
+			name:       "Get",
+			args:       []string{"get", "1"},
+			wantCode:   0,
+			wantStdout: "An old silent pond...",
+		},
+		{
+			name:       "Get with flags after the ID",
+			args:       []string{"get", "1", "-format", "json"},
+			wantCode:   0,
+			wantStdout: `"title": "An old silent pond"`,
+		},
+		{
+			name:       "Get missing snippet",
+			args:       []string{"get", "2"},
+			wantCode:   1,
+			wantStderr: "(404)",
+		},
+		{
+			name:       "Get invalid ID",
+			args:       []string{"get", "foo"},
+			wantCode:   2,
+			wantStderr: `invalid snippet ID "foo"`,
+		},
+		{
+			name:       "List",
+			args:       []string{"list"},
+			wantCode:   0,
+			wantStdout: "1   An old silent pond",
+		},
+		{
+			name:       "List with JSON output",
+			args:       []string{"list", "-format", "json"},
+			wantCode:   0,
+			wantStdout: `"total_records": 1`,
+		},
+		{
+			name:       "Delete",
+			args:       []string{"delete", "1"},
+			token:      mocks.WriteToken,
+			wantCode:   0,
+			wantStdout: "Deleted snippet 1",
+		},
+		{
+			name:       "Delete with invalid token",
+			args:       []string{"delete", "1"},
+			token:      "sbx_WRONG",
+			wantCode:   1,
+			wantStderr: "(401)",
+		},
+		{
+			name:       "Unknown command",
+			args:       []string{"frobnicate"},
+			wantCode:   2,
+			wantStderr: `unknown command "frobnicate"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			env := cli.Env{
+				Stdin:  strings.NewReader(tt.stdin),
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Getenv: func(key string) string {
+					switch key {
+					case "SNIPPETBOX_SERVER":
+						return ts.URL
+					case "SNIPPETBOX_TOKEN":
+						return tt.token
+					}
+					return ""
+				},
+				HTTPClient: ts.Client(),
+			}
+
+			args := append(tt.args, "-config", config)
+			code := cli.Run(context.Background(), args, env)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantStdout != "" {
+				assert.StringContains(t, stdout.String(), tt.wantStdout)
+			}
+			if tt.wantStderr != "" {
+				assert.StringContains(t, stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+// multiFileSnippetModel is the mock snippet model, with a second file added to
+// the mock snippet.
+type multiFileSnippetModel struct {
+	mocks.SnippetModel
+}
+
+func (m *multiFileSnippetModel) Get(ctx context.Context, id int) (models.Snippet, error) {
+	s, err := m.SnippetModel.Get(ctx, id)
+	s.Files = append(s.Files, models.File{Name: "frog.txt", Language: "plaintext", Content: "A frog jumps in\n"})
+	return s, err
+}
+
+func TestCLIGetFiles(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippets = &multiFileSnippetModel{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	var stdout, stderr bytes.Buffer
+
+	env := cli.Env{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Getenv: func(key string) string {
+			if key == "SNIPPETBOX_SERVER" {
+				return ts.URL
+			}
+			return ""
+		},
+		HTTPClient: ts.Client(),
+	}
+
+	config := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(config, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := cli.Run(context.Background(), []string{"get", "1", "-config", config}, env)
+	assert.Equal(t, code, 0)
+
+	// The output is the same as the snippet's .txt view.
+	_, _, body := ts.get(t, "/snippet/view/1.txt")
+	assert.Equal(t, stdout.String(), body+"\n")
+	assert.Equal(t, stdout.String(), "==> pond.txt <==\nAn old silent pond...\n\n==> frog.txt <==\nA frog jumps in\n")
+}
+
+func TestCLIConfig(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	err := os.WriteFile(config, []byte("# Test config\nserver = "+ts.URL+"\ntoken = "+mocks.WriteToken+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		config     string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "Server and token from config",
+			config:     config,
+			wantCode:   0,
+			wantStdout: "Deleted snippet 1",
+		},
+		{
+			name:       "Missing config file",
+			config:     filepath.Join(dir, "missing"),
+			wantCode:   1,
+			wantStderr: "no such file or directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			env := cli.Env{
+				Stdout:     &stdout,
+				Stderr:     &stderr,
+				Getenv:     func(string) string { return "" },
+				HTTPClient: ts.Client(),
+			}
+
+			code := cli.Run(context.Background(), []string{"delete", "1", "-config", tt.config}, env)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantStdout != "" {
+				assert.StringContains(t, stdout.String(), tt.wantStdout)
+			}
+			if tt.wantStderr != "" {
+				assert.StringContains(t, stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+// A collectionMembership is one of the current user's collections, as listed
+// on the view page of a snippet, with where the snippet is in it.
+type collectionMembership struct {
+	models.Collection
+	Position int // position of the snippet in the collection, counting from 1, or 0 if it isn't in it
+}
+
+type collectionCreateForm struct {
+	Name                string `form:"name"`
+	Description         string `form:"description"`
+	validator.Validator `form:"-"`
+}
+
+// collectionSnippetForm changes the snippets in a collection. Action is one of
+// "add", "remove", "up" or "down", and Back says which page to go back to
+// afterwards: the snippet's ("snippet") or the collection's ("collection").
+type collectionSnippetForm struct {
+	SnippetID int    `form:"snippet_id"`
+	Action    string `form:"action"`
+	Back      string `form:"back"`
+}
+
+// collectionMemberships returns the user's collections, with the position of
+// the snippet in each of them.
+func (app *application) collectionMemberships(r *http.Request, userID, snippetID int) ([]collectionMembership, error) {
+	collections, err := app.collections.ForUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships := make([]collectionMembership, len(collections))
+	for i, c := range collections {
+		memberships[i] = collectionMembership{Collection: c}
+		for j, id := range c.SnippetIDs {
+			if id == snippetID {
+				memberships[i].Position = j + 1
+			}
+		}
+	}
+
+	return memberships, nil
+}
+
+func (app *application) accountCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.collections.ForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+	data.Form = collectionCreateForm{}
+
+	app.render(w, r, http.StatusOK, "collections.tmpl", data)
+}
+
+func (app *application) accountCollectionCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form collectionCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.MaxChars(form.Description, 1000), "description", "This field cannot be more than 1000 characters long")
+
+	if !form.Valid() {
+		collections, err := app.collections.ForUser(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Collections = collections
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "collections.tmpl", data)
+		return
+	}
+
+	id, err := app.collections.Insert(r.Context(), userID, form.Name, form.Description)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your collection has been created.")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", id), http.StatusSeeOther)
+}
+
+// collectionView shows a collection with the full content of all its
+// snippets. Like snippets, collections can be viewed by anyone.
+func (app *application) collectionView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	collection, err := app.collections.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.OwnsCollection = data.IsAuthenticated && collection.UserID == app.authenticatedUserID(r)
+
+	app.render(w, r, http.StatusOK, "collection.tmpl", data)
+}
+
+func (app *application) collectionDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.collections.Delete(r.Context(), id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your collection has been deleted.")
+
+	http.Redirect(w, r, "/account/collections", http.StatusSeeOther)
+}
+
+// collectionSnippetPost adds a snippet to one of the user's collections, or
+// removes or moves it. Adding and removing are idempotent.
+func (app *application) collectionSnippetPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var form collectionSnippetForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	switch form.Action {
+	case "add":
+		err = app.collections.AddSnippet(r.Context(), id, userID, form.SnippetID)
+	case "remove":
+		err = app.collections.RemoveSnippet(r.Context(), id, userID, form.SnippetID)
+	case "up", "down":
+		err = app.collections.MoveSnippet(r.Context(), id, userID, form.SnippetID, form.Action == "up")
+	default:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if form.Back == "collection" {
+		http.Redirect(w, r, fmt.Sprintf("/collection/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", form.SnippetID), http.StatusSeeOther)
+}
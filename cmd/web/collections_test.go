@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestCollectionView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Collections can be viewed by anyone, but only changed by their owner.
+	code, _, body := ts.get(t, "/collection/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<h2>Haiku</h2>")
+	assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+	assert.StringContains(t, body, "An old silent pond...")
+	assert.Equal(t, strings.Contains(body, "<form class='collection-snippet'"), false)
+
+	code, _, _ = ts.get(t, "/collection/99")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/collection/1")
+	assert.StringContains(t, body, "<button name='action' value='remove'>Remove</button>")
+	assert.StringContains(t, body, "<form class='collection-delete' action='/collection/1/delete' method='POST'>")
+
+	_, _, body = ts.get(t, "/collection/3")
+	assert.Equal(t, strings.Contains(body, "<form class='collection-delete'"), false)
+
+	// The view page of a snippet lists the user's collections, with the
+	// snippet's place in each.
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<span>1 of 1</span>")
+	assert.StringContains(t, body, "<a href='/collection/2'>Runbooks</a>")
+	assert.StringContains(t, body, "<button name='action' value='add'>Add</button>")
+}
+
+func TestAccountCollections(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/account/collections")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/collections")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<a href='/collection/1'>Haiku</a>")
+	assert.Equal(t, strings.Contains(body, "Bob&#39;s snippets"), false)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		collName     string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid submission",
+			collName:     "Recipes",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/collection/4",
+		},
+		{
+			name:     "Blank name",
+			collName: "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Long name",
+			collName: strings.Repeat("a", 101),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 100 characters long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.collName)
+			form.Add("description", "")
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/account/collections", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestCollectionSnippetPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/collections")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Add",
+			urlPath:      "/collection/2/snippets",
+			form:         url.Values{"snippet_id": {"1"}, "action": {"add"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:         "Move up from the collection page",
+			urlPath:      "/collection/1/snippets",
+			form:         url.Values{"snippet_id": {"1"}, "action": {"up"}, "back": {"collection"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/collection/1",
+		},
+		{
+			name:         "Remove",
+			urlPath:      "/collection/1/snippets",
+			form:         url.Values{"snippet_id": {"1"}, "action": {"remove"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/collection/2/snippets",
+			form:     url.Values{"snippet_id": {"99"}, "action": {"add"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Someone else's collection",
+			urlPath:  "/collection/3/snippets",
+			form:     url.Values{"snippet_id": {"1"}, "action": {"add"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown action",
+			urlPath:  "/collection/1/snippets",
+			form:     url.Values{"snippet_id": {"1"}, "action": {"sort"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Delete",
+			urlPath:      "/collection/2/delete",
+			form:         url.Values{},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/collections",
+		},
+		{
+			name:     "Delete someone else's collection",
+			urlPath:  "/collection/3/delete",
+			form:     url.Values{},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, tt.form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"snippetbox.dkimhw.com/internal/mailer"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+// The number of general comment threads shown on each page of a snippet.
+const commentsPerPage = 20
+
+// A commentThread is a thread of comments as displayed on the view page,
+// along with what its template needs to know about the rest of the page.
+type commentThread struct {
+	Comments      []models.Comment // the first comment, then its replies
+	CurrentUserID int              // 0 if nobody is logged in
+	CSRFToken     string           // for the reply form
+	Page          int              // the page of general threads being shown
+}
+
+// A fileView is one of a snippet's files as displayed on the view page. Files
+// with line comments are shown line by line, with the threads for each line
+// underneath it.
+type fileView struct {
+	models.File
+	Index int
+	Lines []annotatedLine // nil unless the file has line comments
+}
+
+type annotatedLine struct {
+	Number  int
+	Text    string
+	Threads []commentThread
+}
+
+// pagination describes which page of a list is being shown.
+type pagination struct {
+	Page  int
+	Pages int
+}
+
+func (p pagination) Previous() int { return p.Page - 1 }
+func (p pagination) Next() int     { return p.Page + 1 }
+
+type commentForm struct {
+	Body                string `form:"body"`
+	ParentID            int    `form:"parent_id"`
+	File                int    `form:"file"`
+	Line                int    `form:"line"`
+	Page                int    `form:"page"`
+	validator.Validator `form:"-"`
+}
+
+// fileLines splits a file's content into lines. A newline at the end of the
+// file doesn't start another line.
+func fileLines(content string) []string {
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func validateComment(v *validator.Validator, body string) {
+	v.CheckField(validator.NotBlank(body), "body", "This field cannot be blank")
+	v.CheckField(validator.MaxChars(body, 10000), "body", "This field cannot be more than 10000 characters long")
+}
+
+// renderSnippetPage renders the HTML view of a snippet, along with its forks,
+// comments and the current user's collections. The form is the one for adding
+// a comment, which is re-displayed with its errors if it was invalid.
+func (app *application) renderSnippetPage(w http.ResponseWriter, r *http.Request, status int, snippet models.Snippet, form commentForm) {
+	var err error
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = form
+	data.EmbedURL = fmt.Sprintf("%s/embed/%d", app.baseURL, snippet.ID)
+	data.OEmbedURL = "/oembed?url=" + url.QueryEscape(fmt.Sprintf("%s/snippet/view/%d", app.baseURL, snippet.ID))
+
+	// Advertise the feeds which this snippet appears in.
+	if snippet.UserID != 0 {
+		data.Feeds = append(data.Feeds, feedLinks("Snippets by this author", fmt.Sprintf("/user/%d/feed", snippet.UserID))...)
+	}
+	for _, tag := range snippet.Tags {
+		data.Feeds = append(data.Feeds, feedLinks("Snippets tagged "+tag, "/tag/"+tag+"/feed")...)
+	}
+
+	data.Forks, err = app.snippets.Forks(r.Context(), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	if data.IsAuthenticated {
+		data.Starred, err = app.stars.Starred(r.Context(), userID, snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data.Memberships, err = app.collectionMemberships(r, userID, snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	page := form.Page
+	if page < 1 {
+		page, err = strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+	}
+
+	thread := func(c models.Comment) commentThread {
+		return commentThread{
+			Comments:      append([]models.Comment{c}, c.Replies...),
+			CurrentUserID: userID,
+			CSRFToken:     data.CSRFToken,
+			Page:          page,
+		}
+	}
+
+	threads, total, err := app.comments.Threads(r.Context(), snippet.ID, page, commentsPerPage)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	for _, c := range threads {
+		data.Threads = append(data.Threads, thread(c))
+	}
+	data.CommentPages = pagination{Page: page, Pages: (total + commentsPerPage - 1) / commentsPerPage}
+
+	lineThreads, err := app.comments.LineThreads(r.Context(), snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data.Files = make([]fileView, len(snippet.Files))
+	for i, f := range snippet.Files {
+		data.Files[i] = fileView{File: f, Index: i}
+	}
+
+	// Put each line thread under its line. The snippet may have been edited
+	// since the comment was made, so the line (or the whole file) may have
+	// gone; those threads are listed separately.
+	for _, c := range lineThreads {
+		if c.File >= len(data.Files) {
+			data.OutdatedThreads = append(data.OutdatedThreads, thread(c))
+			continue
+		}
+
+		f := &data.Files[c.File]
+		if f.Lines == nil {
+			for n, text := range fileLines(f.Content) {
+				f.Lines = append(f.Lines, annotatedLine{Number: n + 1, Text: text})
+			}
+		}
+
+		if c.Line > len(f.Lines) {
+			data.OutdatedThreads = append(data.OutdatedThreads, thread(c))
+			continue
+		}
+
+		f.Lines[c.Line-1].Threads = append(f.Lines[c.Line-1].Threads, thread(c))
+	}
+
+	app.render(w, r, status, "view.tmpl", data)
+}
+
+// snippetCommentPost adds a comment to a snippet. A comment with a line
+// number is a comment on that line of one of the snippet's files, and one
+// with a parent_id is a reply to that comment's thread.
+func (app *application) snippetCommentPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form commentForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	validateComment(&form.Validator, form.Body)
+
+	// Replies are anchored to the same line as the rest of their thread, so
+	// the line is only checked for new threads.
+	if form.ParentID == 0 && form.Line != 0 {
+		ok := form.File >= 0 && form.File < len(snippet.Files) &&
+			form.Line > 0 && form.Line <= len(fileLines(snippet.Files[form.File].Content))
+		form.CheckField(ok, "line", "This line doesn't exist in the file")
+	}
+
+	if !form.Valid() {
+		app.renderSnippetPage(w, r, http.StatusUnprocessableEntity, snippet, form)
+		return
+	}
+
+	commentID, err := app.comments.Insert(r.Context(), snippet.ID, app.authenticatedUserID(r), form.ParentID, form.File, form.Line, form.Body)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.notifyCommentCreated(r, snippet, commentID, form.Page)
+
+	http.Redirect(w, r, commentURL(snippet.ID, form.Page, commentID), http.StatusSeeOther)
+}
+
+// emailCommentCreated emails the snippet's owner about a new comment, as long
+// as their address has been verified. The email is sent in the background, so
+// that a slow mail server doesn't hold up the response.
+func (app *application) emailCommentCreated(r *http.Request, snippet models.Snippet, comment models.Comment, page int) {
+	logger := app.requestLogger(r)
+
+	owner, err := app.users.Get(r.Context(), snippet.UserID)
+	if err != nil {
+		logger.Error("fetching snippet owner for comment email", slog.Int("user_id", snippet.UserID), slog.String("error", err.Error()))
+		return
+	}
+	if !owner.EmailVerified {
+		return
+	}
+
+	msg := mailer.Message{
+		To:      owner.Email,
+		Subject: fmt.Sprintf("New comment on your snippet #%d", snippet.ID),
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"%s commented on your snippet \"%s\":\n\n%s\n\n"+
+			"To read it and reply, follow this link:\n\n%s\n",
+			owner.Name, comment.UserName, snippet.Title, comment.Body, app.baseURL+commentURL(snippet.ID, page, comment.ID)),
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		err := app.mailer.Send(ctx, msg)
+		if err != nil {
+			logger.Error("sending comment email", slog.Int("comment_id", comment.ID), slog.String("error", err.Error()))
+		}
+	})
+}
+
+// commentURL returns the URL of a comment on the view page.
+func commentURL(snippetID, page, commentID int) string {
+	if page > 1 {
+		return fmt.Sprintf("/snippet/view/%d?page=%d#comment-%d", snippetID, page, commentID)
+	}
+	return fmt.Sprintf("/snippet/view/%d#comment-%d", snippetID, commentID)
+}
+
+type commentEditForm struct {
+	Body                string `form:"body"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) commentEdit(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.readOwnComment(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Comment = comment
+	data.Form = commentEditForm{Body: comment.Body}
+
+	app.render(w, r, http.StatusOK, "comment.tmpl", data)
+}
+
+func (app *application) commentEditPost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.readOwnComment(w, r)
+	if !ok {
+		return
+	}
+
+	var form commentEditForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	validateComment(&form.Validator, form.Body)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Comment = comment
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "comment.tmpl", data)
+		return
+	}
+
+	err = app.comments.Update(r.Context(), comment.ID, comment.UserID, form.Body)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, commentURL(comment.SnippetID, 1, comment.ID), http.StatusSeeOther)
+}
+
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.readOwnComment(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.comments.Delete(r.Context(), comment.ID, comment.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your comment has been deleted.")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", comment.SnippetID), http.StatusSeeOther)
+}
+
+// readOwnComment fetches the comment with the ID in the URL, if it was written
+// by the current user. Otherwise it sends a 404 Not Found response, so that
+// users can't tell which other comments exist.
+func (app *application) readOwnComment(w http.ResponseWriter, r *http.Request) (models.Comment, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return models.Comment{}, false
+	}
+
+	comment, err := app.comments.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Comment{}, false
+	}
+
+	if comment.UserID != app.authenticatedUserID(r) {
+		http.NotFound(w, r)
+		return models.Comment{}, false
+	}
+
+	return comment, true
+}
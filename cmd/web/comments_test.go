@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/mailer"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+func TestSnippetViewComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+
+		assert.StringContains(t, body, "<div class='comment-body'><p>Is this <strong>finished</strong>?</p>")
+		assert.StringContains(t, body, "<div class='comment reply' id='comment-2'>")
+		assert.Equal(t, strings.Contains(body, "<form class='reply'"), false)
+		assert.Equal(t, strings.Contains(body, "<form class='comment-form'"), false)
+	})
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+
+	// The line comment is shown under the line it is on.
+	assert.StringContains(t, body, "<tr id='file-0-line-1'>")
+	assert.StringContains(t, body, "<div class='comment-body'><p>Consider <code>a frog</code> here.</p>")
+
+	// Alice can only edit her own comment.
+	assert.StringContains(t, body, "<a href='/comment/1/edit'>Edit</a>")
+	assert.Equal(t, strings.Contains(body, "<a href='/comment/2/edit'>"), false)
+	assert.Equal(t, strings.Contains(body, "<a href='/comment/3/edit'>"), false)
+
+	assert.StringContains(t, body, "<input type='hidden' name='parent_id' value='1'>")
+	assert.StringContains(t, body, "<form class='comment-form' action='/snippet/comment/1' method='POST'>")
+}
+
+func TestSnippetCommentPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "General comment",
+			urlPath:      "/snippet/comment/1",
+			form:         url.Values{"body": {"Looks good"}, "file": {"0"}, "line": {""}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-4",
+		},
+		{
+			name:         "Line comment",
+			urlPath:      "/snippet/comment/1",
+			form:         url.Values{"body": {"Typo"}, "file": {"0"}, "line": {"1"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-4",
+		},
+		{
+			name:         "Reply",
+			urlPath:      "/snippet/comment/1",
+			form:         url.Values{"body": {"Done"}, "parent_id": {"1"}, "page": {"2"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1?page=2#comment-4",
+		},
+		{
+			name:     "Blank body",
+			urlPath:  "/snippet/comment/1",
+			form:     url.Values{"body": {"  "}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Line past the end",
+			urlPath:  "/snippet/comment/1",
+			form:     url.Values{"body": {"Typo"}, "file": {"0"}, "line": {"2"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This line doesn&#39;t exist in the file",
+		},
+		{
+			name:     "Non-existent file",
+			urlPath:  "/snippet/comment/1",
+			form:     url.Values{"body": {"Typo"}, "file": {"1"}, "line": {"1"}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This line doesn&#39;t exist in the file",
+		},
+		{
+			name:     "Non-existent parent",
+			urlPath:  "/snippet/comment/1",
+			form:     url.Values{"body": {"Done"}, "parent_id": {"99"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/comment/2",
+			form:     url.Values{"body": {"Hello"}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.urlPath, tt.form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestCommentCreatedEmail(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := app.mailer.(*mailer.MemoryOutbox)
+
+	// Alice owns the mock snippet, so commenting on it herself doesn't send
+	// her an email.
+	alice := newTestServer(t, app.routes())
+	defer alice.Close()
+
+	alice.login(t, "alice@example.com", "pa$$word")
+	_, _, body := alice.get(t, "/snippet/view/1")
+
+	form := url.Values{"body": {"Looks good"}, "csrf_token": {extractCSRFToken(t, body)}}
+	code, _, _ := alice.postForm(t, "/snippet/comment/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.backgroundTasks.Wait()
+	assert.Equal(t, len(outbox.Messages()), 0)
+
+	// A comment from someone else does.
+	dave := newTestServer(t, app.routes())
+	defer dave.Close()
+
+	dave.loginTwoFactor(t, totpCode(t, mocks.TOTPSecret, time.Now()))
+	_, _, body = dave.get(t, "/snippet/view/1")
+
+	form = url.Values{"body": {"Done"}, "parent_id": {"1"}, "page": {"2"}, "csrf_token": {extractCSRFToken(t, body)}}
+	code, _, _ = dave.postForm(t, "/snippet/comment/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.backgroundTasks.Wait()
+	messages := outbox.Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].To, "alice@example.com")
+	assert.Equal(t, messages[0].Subject, "New comment on your snippet #1")
+	assert.StringContains(t, messages[0].Body, "Dave commented on your snippet \"An old silent pond\":\n\nDone")
+	assert.StringContains(t, messages[0].Body, "https://snippetbox.example.com/snippet/view/1?page=2#comment-4")
+}
+
+func TestCommentEdit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/comment/1/edit")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<textarea name='body'>Is this **finished**?</textarea>")
+	csrfToken := extractCSRFToken(t, body)
+
+	// Comment 2 was written by someone else.
+	code, _, _ = ts.get(t, "/comment/2/edit")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		body         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Edit",
+			urlPath:      "/comment/1/edit",
+			body:         "Is this finished now?",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-1",
+		},
+		{
+			name:     "Edit with blank body",
+			urlPath:  "/comment/1/edit",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Edit someone else's comment",
+			urlPath:  "/comment/2/edit",
+			body:     "Yes it is",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Delete",
+			urlPath:      "/comment/1/delete",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Delete someone else's comment",
+			urlPath:  "/comment/3/delete",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
@@ -2,4 +2,10 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey     = contextKey("isAuthenticated")
+	authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+	tokenContextKey               = contextKey("token")
+	requestIDContextKey           = contextKey("requestID")
+	loggerContextKey              = contextKey("logger")
+)
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The default size of an embedded snippet, in pixels.
+const (
+	embedWidth  = 600
+	embedHeight = 300
+)
+
+// The embedView handler serves a snippet for display in an iframe on another
+// site, without the navigation and layout of the other pages. With a ".js"
+// suffix on the ID it instead serves a script which inserts that iframe into
+// the page, for sites which would rather use a <script> tag.
+func (app *application) embedView(w http.ResponseWriter, r *http.Request) {
+	idParam, isScript := strings.CutSuffix(r.PathValue("id"), ".js")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if isScript {
+		app.embedScript(w, r, snippet)
+		return
+	}
+
+	// This route doesn't use sessions (embedding sites shouldn't get a
+	// session cookie), so we can't use newTemplateData() here.
+	data := templateData{
+		CurrentYear: time.Now().Year(),
+		Snippet:     snippet,
+	}
+
+	app.render(w, r, http.StatusOK, "embed.tmpl", data)
+}
+
+// embedScriptTemplate is the script served for script-tag embeds. It inserts
+// an iframe for the snippet straight after the <script> tag which loaded it.
+const embedScriptTemplate = `(function () {
+  var script = document.currentScript;
+  var iframe = document.createElement("iframe");
+  iframe.src = %s;
+  iframe.title = %s;
+  iframe.width = "%d";
+  iframe.height = "%d";
+  iframe.loading = "lazy";
+  iframe.style.border = "0";
+  script.parentNode.insertBefore(iframe, script.nextSibling);
+})();
+`
+
+func (app *application) embedScript(w http.ResponseWriter, r *http.Request, snippet models.Snippet) {
+	// Encoding the strings as JSON gives us valid JavaScript string literals,
+	// with characters such as < and > escaped so that they can't close the
+	// script element.
+	src, err := json.Marshal(fmt.Sprintf("%s/embed/%d", app.baseURL, snippet.ID))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	title, err := json.Marshal(snippet.Title)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	fmt.Fprintf(w, embedScriptTemplate, src, title, embedWidth, embedHeight)
+}
+
+// oEmbedResponse is the response for a "rich" type oEmbed request, as
+// described at https://oembed.com.
+type oEmbedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// The oEmbed handler lets wikis and other tools turn links to snippets into
+// embedded snippets. The url parameter must be a link to a snippet on this
+// site, and the optional maxwidth and maxheight parameters limit the size of
+// the embed. Only the JSON format is supported.
+func (app *application) oEmbed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if format := query.Get("format"); format != "" && format != "json" {
+		http.Error(w, "Only the json format is supported", http.StatusNotImplemented)
+		return
+	}
+
+	if query.Get("url") == "" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, ok := snippetIDFromURL(app.baseURL, query.Get("url"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	width := limitDimension(embedWidth, query.Get("maxwidth"))
+	height := limitDimension(embedHeight, query.Get("maxheight"))
+
+	resp := oEmbedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        snippet.Title,
+		ProviderName: "Snippetbox",
+		ProviderURL:  app.baseURL + "/",
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" loading="lazy" style="border: 0"></iframe>`,
+			html.EscapeString(fmt.Sprintf("%s/embed/%d", app.baseURL, snippet.ID)), width, height, html.EscapeString(snippet.Title)),
+		Width:  width,
+		Height: height,
+	}
+
+	if snippet.UserID != 0 {
+		user, err := app.users.Get(r.Context(), snippet.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		resp.AuthorName = user.Name
+	}
+
+	// The response only contains public information, so let browser-based
+	// consumers fetch it too.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	err = app.writeJSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// snippetIDFromURL returns the ID of the snippet which rawURL links to,
+// provided it is a snippet or embed page on this site.
+func snippetIDFromURL(baseURL, rawURL string) (int, bool) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return 0, false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host != base.Host {
+		return 0, false
+	}
+
+	idParam, ok := strings.CutPrefix(u.Path, "/snippet/view/")
+	if !ok {
+		idParam, ok = strings.CutPrefix(u.Path, "/embed/")
+	}
+	if !ok {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil || id < 1 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// limitDimension returns size, reduced to the limit given by a maxwidth or
+// maxheight parameter if there is a valid one.
+func limitDimension(size int, limit string) int {
+	n, err := strconv.Atoi(limit)
+	if err == nil && n > 0 && n < size {
+		return n
+	}
+	return size
+}
+
+// parseOrigins parses a comma-separated list of origins, such as
+// "https://wiki.example.com, https://docs.example.com:8443".
+func parseOrigins(s string) ([]string, error) {
+	var origins []string
+
+	for _, origin := range strings.Split(s, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid origin %q: must be of the form https://host[:port]", origin)
+		}
+
+		origins = append(origins, u.Scheme+"://"+u.Host)
+	}
+
+	return origins, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestEmbedView(t *testing.T) {
+	app := newTestApplication(t)
+	app.embedOrigins = []string{"https://wiki.example.com"}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Frame",
+			urlPath:         "/embed/1",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<pre><code>An old silent pond...</code></pre>",
+		},
+		{
+			name:            "Script",
+			urlPath:         "/embed/1.js",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/javascript; charset=utf-8",
+			wantBody:        `iframe.src = "` + app.baseURL + `/embed/1";`,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/embed/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid ID",
+			urlPath:  "/embed/foo.js",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, headers.Get("Content-Type"), tt.wantContentType)
+			assert.StringContains(t, body, tt.wantBody)
+
+			// Only our own pages and the allowed origins can frame the
+			// embed, and the embedding site mustn't be given a cookie.
+			assert.StringContains(t, headers.Get("Content-Security-Policy"), "frame-ancestors 'self' https://wiki.example.com")
+			assert.Equal(t, headers.Get("X-Frame-Options"), "")
+			assert.Equal(t, headers.Get("Set-Cookie"), "")
+		})
+	}
+
+	// Other pages still can't be framed at all.
+	_, headers, _ := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, headers.Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	assert.Equal(t, headers.Get("X-Frame-Options"), "deny")
+}
+
+func TestOEmbed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantCode   int
+		wantWidth  int
+		wantHeight int
+	}{
+		{
+			name:       "Snippet URL",
+			query:      url.Values{"url": {app.baseURL + "/snippet/view/1"}},
+			wantCode:   http.StatusOK,
+			wantWidth:  600,
+			wantHeight: 300,
+		},
+		{
+			name:       "Embed URL with size limits",
+			query:      url.Values{"url": {app.baseURL + "/embed/1"}, "format": {"json"}, "maxwidth": {"400"}, "maxheight": {"1000"}},
+			wantCode:   http.StatusOK,
+			wantWidth:  400,
+			wantHeight: 300,
+		},
+		{
+			name:     "Missing URL",
+			query:    url.Values{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Other site",
+			query:    url.Values{"url": {"https://example.com/snippet/view/1"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Not a snippet",
+			query:    url.Values{"url": {app.baseURL + "/about"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent snippet",
+			query:    url.Values{"url": {app.baseURL + "/snippet/view/2"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "XML format",
+			query:    url.Values{"url": {app.baseURL + "/snippet/view/1"}, "format": {"xml"}},
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, "/oembed?"+tt.query.Encode())
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, headers.Get("Access-Control-Allow-Origin"), "*")
+
+			var resp oEmbedResponse
+			err := json.Unmarshal([]byte(body), &resp)
+			assert.NilError(t, err)
+
+			assert.Equal(t, resp.Type, "rich")
+			assert.Equal(t, resp.Title, "An old silent pond")
+			assert.Equal(t, resp.AuthorName, "Alice")
+			assert.Equal(t, resp.Width, tt.wantWidth)
+			assert.Equal(t, resp.Height, tt.wantHeight)
+			assert.StringContains(t, resp.HTML, `<iframe src="`+app.baseURL+`/embed/1"`)
+		})
+	}
+}
+
+func TestParseOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Empty", input: "", want: ""},
+		{name: "Multiple", input: "https://wiki.example.com, http://localhost:8080/", want: "https://wiki.example.com http://localhost:8080"},
+		{name: "Path", input: "https://example.com/wiki", wantErr: true},
+		{name: "No scheme", input: "example.com", wantErr: true},
+		{name: "Wildcard", input: "*", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origins, err := parseOrigins(tt.input)
+
+			assert.Equal(t, err != nil, tt.wantErr)
+			assert.Equal(t, strings.Join(origins, " "), tt.want)
+		})
+	}
+}
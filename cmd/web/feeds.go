@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+// feedTypes maps the {format} path segment of a feed URL to the media type
+// of the feed.
+var feedTypes = map[string]string{
+	"atom": "application/atom+xml",
+	"rss":  "application/rss+xml",
+}
+
+// A feedLink describes a feed for an autodiscovery <link> tag in base.tmpl.
+type feedLink struct {
+	Title string
+	Type  string
+	URL   string
+}
+
+// feedLinks returns the autodiscovery links for both formats of the feed at
+// path, e.g. path "/feed" gives links to "/feed/atom" and "/feed/rss".
+func feedLinks(title, path string) []feedLink {
+	return []feedLink{
+		{Title: title + " (Atom)", Type: feedTypes["atom"], URL: path + "/atom"},
+		{Title: title + " (RSS)", Type: feedTypes["rss"], URL: path + "/rss"},
+	}
+}
+
+// A feed holds everything needed to render a feed in either format.
+type feed struct {
+	title    string
+	author   string
+	path     string // the path of the feed, without the format
+	snippets []models.Snippet
+}
+
+func (app *application) feedLatest(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.Latest(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.serveFeed(w, r, feed{
+		title:    "Latest snippets",
+		author:   "Snippetbox",
+		path:     "/feed",
+		snippets: snippets,
+	})
+}
+
+func (app *application) feedUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	snippets, err := app.snippets.LatestByUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.serveFeed(w, r, feed{
+		title:    "Snippets by " + user.Name,
+		author:   user.Name,
+		path:     fmt.Sprintf("/user/%d/feed", user.ID),
+		snippets: snippets,
+	})
+}
+
+func (app *application) feedTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if !validator.Matches(tag, validator.TagRX) {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippets, err := app.snippets.LatestByTag(r.Context(), tag)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.serveFeed(w, r, feed{
+		title:    "Snippets tagged " + tag,
+		author:   "Snippetbox",
+		path:     "/tag/" + tag + "/feed",
+		snippets: snippets,
+	})
+}
+
+// serveFeed renders the feed in the format given by the {format} path
+// segment. Feed readers poll regularly, so the response has an ETag and a
+// Last-Modified header, and http.ServeContent() takes care of answering
+// conditional requests with 304 Not Modified.
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, f feed) {
+	format := r.PathValue("format")
+
+	contentType, ok := feedTypes[format]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The feed was last updated when its newest snippet was created. This is
+	// the zero time for an empty feed, in which case ServeContent() leaves
+	// out the Last-Modified header (and the Atom feed uses the current time).
+	var updated time.Time
+	for _, s := range f.snippets {
+		if s.Created.After(updated) {
+			updated = s.Created
+		}
+	}
+
+	var doc any
+	if format == "atom" {
+		doc = newAtomFeed(app.baseURL, f, updated)
+	} else {
+		doc = newRSSFeed(app.baseURL, f, updated)
+	}
+
+	buf := bytes.NewBufferString(xml.Header)
+
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Use a hash of the body as the ETag, as the body can change without the
+	// feed being updated (e.g. when a snippet expires).
+	hash := sha256.Sum256(buf.Bytes())
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+
+	http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+}
+
+// entryID returns a tag URI (RFC 4151) identifying a snippet, such as
+// "tag:example.com,2024-01-31:snippet/1". Like the links in the feeds, it uses
+// the host name of the site's configured base URL rather than the one the
+// feed was fetched with, so that it stays the same however the feed is
+// accessed, and can't be chosen by the client.
+func entryID(baseURL string, s models.Snippet) string {
+	host := baseURL
+	if u, err := url.Parse(baseURL); err == nil {
+		host = u.Hostname()
+	}
+
+	return fmt.Sprintf("tag:%s,%s:snippet/%d", host, s.Created.UTC().Format(time.DateOnly), s.ID)
+}
+
+// The atom* types represent an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Updated   string   `xml:"updated"`
+	Published string   `xml:"published"`
+	Link      atomLink `xml:"link"`
+	Content   atomText `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func newAtomFeed(baseURL string, f feed, updated time.Time) atomFeed {
+	selfURL := baseURL + f.path + "/atom"
+
+	// The updated element is required, so an empty feed uses the current time.
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	feed := atomFeed{
+		ID:      selfURL,
+		Title:   f.title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: f.author},
+		Links: []atomLink{
+			{Rel: "self", Type: feedTypes["atom"], Href: selfURL},
+			{Rel: "alternate", Type: "text/html", Href: baseURL + "/"},
+		},
+	}
+
+	for _, s := range f.snippets {
+		created := s.Created.UTC().Format(time.RFC3339)
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        entryID(baseURL, s),
+			Title:     s.Title,
+			Updated:   created,
+			Published: created,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: fmt.Sprintf("%s/snippet/view/%d", baseURL, s.ID)},
+			Content:   atomText{Type: "text", Body: s.Content},
+		})
+	}
+
+	return feed
+}
+
+// The rss* types represent an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func newRSSFeed(baseURL string, f feed, updated time.Time) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.title,
+			Link:        baseURL + "/",
+			Description: f.title + " on Snippetbox",
+		},
+	}
+
+	if !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, s := range f.snippets {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        fmt.Sprintf("%s/snippet/view/%d", baseURL, s.ID),
+			GUID:        rssGUID{IsPermaLink: false, Value: entryID(baseURL, s)},
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: s.Content,
+		})
+	}
+
+	return feed
+}
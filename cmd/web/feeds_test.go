@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestFeeds(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Latest Atom",
+			urlPath:         "/feed/atom",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/atom+xml; charset=utf-8",
+			wantBody:        "<title>An old silent pond</title>",
+		},
+		{
+			name:            "Latest RSS",
+			urlPath:         "/feed/rss",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/rss+xml; charset=utf-8",
+			wantBody:        `<guid isPermaLink="false">tag:snippetbox.example.com,`,
+		},
+		{
+			name:            "User",
+			urlPath:         "/user/1/feed/atom",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/atom+xml; charset=utf-8",
+			wantBody:        "<name>Alice</name>",
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/user/2/feed/atom",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:            "Tag",
+			urlPath:         "/tag/haiku/feed/rss",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/rss+xml; charset=utf-8",
+			wantBody:        "<title>Snippets tagged haiku</title>",
+		},
+		{
+			name:            "Empty tag",
+			urlPath:         "/tag/sonnet/feed/atom",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/atom+xml; charset=utf-8",
+			wantBody:        "<link rel=\"alternate\" type=\"text/html\" href=\"https://snippetbox.example.com/\"></link>",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tag/Haiku!/feed/atom",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown format",
+			urlPath:  "/feed/json",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, headers.Get("Content-Type"), tt.wantContentType)
+			assert.StringContains(t, body, tt.wantBody)
+
+			// Feeds are fetched by feed readers, which have no use for a
+			// session cookie.
+			assert.Equal(t, headers.Get("Set-Cookie"), "")
+
+			// Check that the feed is well-formed XML.
+			err := xml.Unmarshal([]byte(body), new(struct{}))
+			assert.NilError(t, err)
+
+			// Empty feeds use the current time rather than the zero time.
+			assert.Equal(t, strings.Contains(body, "0001-01-01"), false)
+		})
+	}
+}
+
+// Links and entry IDs in feeds use the configured base URL, whatever Host
+// header the feed was requested with.
+func TestFeedHost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, format := range []string{"atom", "rss"} {
+		t.Run(format, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/feed/"+format, nil)
+			assert.NilError(t, err)
+			req.Host = "attacker.example.com"
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			defer rs.Body.Close()
+
+			body, err := io.ReadAll(rs.Body)
+			assert.NilError(t, err)
+
+			assert.Equal(t, rs.StatusCode, http.StatusOK)
+			assert.StringContains(t, string(body), "https://snippetbox.example.com/snippet/view/1")
+			assert.StringContains(t, string(body), "tag:snippetbox.example.com,")
+			assert.Equal(t, strings.Contains(string(body), "attacker.example.com"), false)
+		})
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/feed/atom")
+	assert.Equal(t, code, http.StatusOK)
+
+	etag := headers.Get("ETag")
+	lastModified := headers.Get("Last-Modified")
+	assert.Equal(t, etag != "", true)
+	assert.Equal(t, lastModified != "", true)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{
+			name:     "Matching ETag",
+			headers:  map[string]string{"If-None-Match": etag},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "Different ETag",
+			headers:  map[string]string{"If-None-Match": `"0123456789abcdef"`},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Not modified since",
+			headers:  map[string]string{"If-Modified-Since": lastModified},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "Modified since",
+			headers:  map[string]string{"If-Modified-Since": "Sat, 01 Jan 2000 00:00:00 GMT"},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.do(t, http.MethodGet, "/feed/atom", tt.headers, "")
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestFeedAutodiscovery(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/")
+	assert.StringContains(t, body, `title='Latest snippets (Atom)' href='/feed/atom'>`)
+	assert.Equal(t, strings.Contains(body, "/user/1/feed"), false)
+
+	// A snippet's page also links to the feeds of its author and tags.
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `href='/feed/rss'>`)
+	assert.StringContains(t, body, `href='/user/1/feed/atom'>`)
+	assert.StringContains(t, body, `href='/tag/haiku/feed/rss'>`)
+}
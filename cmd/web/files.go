@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+const (
+	// The most files which a snippet can have.
+	maxFiles = 10
+	// The largest file which a snippet can have, in bytes, which is the most
+	// that the TEXT columns in the database can hold. This applies to uploads
+	// as well as to files entered in the form or sent to the API.
+	maxFileSize = 1<<16 - 1
+	// The largest create form submission, which is enough for the maximum
+	// number of files at the maximum size, plus the other fields.
+	maxCreateRequestSize = maxFiles*maxFileSize + 1<<20
+	// How much of a multipart form to hold in memory. The rest is written to
+	// temporary files, which are removed once the request is complete.
+	maxMultipartMemory = 1 << 20
+)
+
+// The error message for files which are larger than maxFileSize.
+var maxFileSizeMessage = fmt.Sprintf("This field cannot be more than %dKB", (maxFileSize+1)>>10)
+
+// languages lists the languages which files can be marked as. The language
+// is used as a hint for the reader (and for syntax highlighters, via the
+// language-* class on the <code> element).
+var languages = []string{
+	"plaintext", "bash", "c", "css", "dockerfile", "go", "html", "java", "javascript",
+	"json", "markdown", "python", "ruby", "rust", "sql", "toml", "typescript", "yaml",
+}
+
+// languageExtensions maps file name extensions to languages, for guessing the
+// language of files which weren't given one.
+var languageExtensions = map[string]string{
+	".sh":   "bash",
+	".c":    "c",
+	".h":    "c",
+	".css":  "css",
+	".go":   "go",
+	".html": "html",
+	".java": "java",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".py":   "python",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".sql":  "sql",
+	".toml": "toml",
+	".ts":   "typescript",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// detectLanguage guesses the language of a file from its name.
+func detectLanguage(name string) string {
+	if strings.EqualFold(name, "Dockerfile") || strings.HasSuffix(strings.ToLower(name), ".dockerfile") {
+		return "dockerfile"
+	}
+	if language, ok := languageExtensions[strings.ToLower(path.Ext(name))]; ok {
+		return language
+	}
+	return "plaintext"
+}
+
+// normalizeFiles trims the names of a snippet's files, and fills in default
+// names and languages for the files which weren't given them.
+func normalizeFiles(files []models.File) []models.File {
+	normalized := make([]models.File, len(files))
+
+	for i, f := range files {
+		f.Name = strings.TrimSpace(f.Name)
+		if f.Name == "" {
+			f.Name = models.DefaultFileName(i)
+		}
+		if f.Language == "" {
+			f.Language = detectLanguage(f.Name)
+		}
+		normalized[i] = f
+	}
+
+	return normalized
+}
+
+// validateFiles checks a snippet's files, which should already have been
+// normalized with normalizeFiles(). Errors about a particular file use keys
+// like "files.0.content".
+func validateFiles(v *validator.Validator, files []models.File) {
+	v.CheckField(len(files) > 0, "files", "A snippet must have at least one file")
+	v.CheckField(len(files) <= maxFiles, "files", fmt.Sprintf("A snippet cannot have more than %d files", maxFiles))
+
+	seen := make(map[string]bool, len(files))
+
+	for i, f := range files {
+		key := fmt.Sprintf("files.%d.", i)
+
+		v.CheckField(validator.MaxChars(f.Name, 255), key+"name", "This field cannot be more than 255 characters long")
+		v.CheckField(validator.Matches(f.Name, validator.FileNameRX) && f.Name != "." && f.Name != "..", key+"name",
+			"File names can only contain letters, numbers, spaces and . _ + -")
+		v.CheckField(!seen[strings.ToLower(f.Name)], key+"name", "Another file already has this name")
+		v.CheckField(validator.PermittedValue(f.Language, languages...), key+"language", "This field must be one of the listed languages")
+		v.CheckField(validator.NotBlank(f.Content), key+"content", "This field cannot be blank")
+		v.CheckField(validator.MaxBytes(f.Content, maxFileSize), key+"content", maxFileSizeMessage)
+
+		seen[strings.ToLower(f.Name)] = true
+	}
+}
+
+// readUploads reads the files uploaded in the create form's upload field,
+// which must be UTF-8 text. Any problems are recorded as errors for the upload
+// field, and the files which had problems are left out.
+func readUploads(v *validator.Validator, r *http.Request) []models.File {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	var files []models.File
+
+	for _, header := range r.MultipartForm.File["upload"] {
+		// Browsers send an empty part when no file was chosen.
+		if header.Filename == "" && header.Size == 0 {
+			continue
+		}
+
+		name := path.Base(header.Filename)
+
+		if header.Size > maxFileSize {
+			v.AddFieldError("upload", fmt.Sprintf("%s is larger than the %dKB limit", name, (maxFileSize+1)>>10))
+			continue
+		}
+
+		content, err := readUpload(header)
+		if err != nil {
+			v.AddFieldError("upload", fmt.Sprintf("%s could not be read", name))
+			continue
+		}
+
+		switch {
+		case isBinary(content):
+			v.AddFieldError("upload", fmt.Sprintf("%s looks like a binary file; only text files can be uploaded", name))
+			continue
+		case !utf8.Valid(content):
+			v.AddFieldError("upload", fmt.Sprintf("%s is not UTF-8 text", name))
+			continue
+		}
+
+		files = append(files, models.File{Name: name, Content: string(content)})
+	}
+
+	return files
+}
+
+func readUpload(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(io.LimitReader(f, maxFileSize))
+}
+
+// isBinary reports whether data looks like the content of a binary file,
+// using the same test as Git: whether there's a NUL byte near the start.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// writeFilesText writes a snippet's files as plain text. A snippet with one
+// file is written as it is; otherwise each file is preceded by a header with
+// its name, in the same style as head(1).
+func writeFilesText(w io.Writer, snippet models.Snippet) {
+	if len(snippet.Files) < 2 {
+		io.WriteString(w, snippet.Content)
+		return
+	}
+
+	for i, f := range snippet.Files {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		fmt.Fprintf(w, "==> %s <==\n", f.Name)
+		io.WriteString(w, f.Content)
+		if !strings.HasSuffix(f.Content, "\n") {
+			io.WriteString(w, "\n")
+		}
+	}
+}
+
+// serveZip sends a snippet's files as a zip archive.
+func (app *application) serveZip(w http.ResponseWriter, r *http.Request, snippet models.Snippet) {
+	// Build the archive in memory, so that we can still send an error
+	// response if something goes wrong.
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+	for _, f := range snippet.Files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: snippet.Created,
+		})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		_, err = io.WriteString(fw, f.Content)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypeZip)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%d.zip"`, snippet.ID))
+	w.Write(buf.Bytes())
+}
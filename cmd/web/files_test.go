@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+func TestSnippetCreateFiles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     []string
+	}{
+		{
+			name: "Valid submission",
+			form: url.Values{
+				"title":             {"Docker setup"},
+				"files[0].name":     {"Dockerfile"},
+				"files[0].content":  {"FROM golang:1.22"},
+				"files[1].name":     {"compose.yml"},
+				"files[1].language": {"yaml"},
+				"files[1].content":  {"services: {}"},
+				"expires":           {"7"},
+			},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name: "Add file",
+			form: url.Values{
+				"title":            {"Docker setup"},
+				"files[0].name":    {"Dockerfile"},
+				"files[0].content": {"FROM golang:1.22"},
+				"add_file":         {"true"},
+				"expires":          {"7"},
+			},
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"<textarea name='files[0].content'>FROM golang:1.22</textarea>",
+				"<textarea name='files[1].content'></textarea>",
+				"<button type='submit' name='remove_file' value='1'>Remove</button>",
+			},
+		},
+		{
+			name: "Remove file",
+			form: url.Values{
+				"title":            {"Docker setup"},
+				"files[0].name":    {"Dockerfile"},
+				"files[0].content": {"FROM golang:1.22"},
+				"files[1].name":    {"compose.yml"},
+				"files[1].content": {"services: {}"},
+				"remove_file":      {"0"},
+				"expires":          {"7"},
+			},
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"<textarea name='files[0].content'>services: {}</textarea>",
+			},
+		},
+		{
+			name: "Invalid files",
+			form: url.Values{
+				"title":             {"Docker setup"},
+				"files[0].name":     {"../Dockerfile"},
+				"files[0].content":  {"FROM golang:1.22"},
+				"files[1].language": {"cobol"},
+				"files[1].content":  {""},
+				"expires":           {"7"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{
+				"File names can only contain letters, numbers, spaces and",
+				"This field must be one of the listed languages",
+				"This field cannot be blank",
+			},
+		},
+		{
+			name: "File too large",
+			form: url.Values{
+				"title":            {"Docker setup"},
+				"files[0].name":    {"Dockerfile"},
+				"files[0].content": {strings.Repeat("a", maxFileSize+1)},
+				"expires":          {"7"},
+			},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This field cannot be more than 64KB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/snippet/create", tt.form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
+
+// An upload is a file to include in a multipart form.
+type upload struct {
+	name    string
+	content []byte
+}
+
+// multipartForm encodes the form fields and uploaded files as a
+// multipart/form-data body, and returns its Content-Type and the body.
+func multipartForm(t *testing.T, form url.Values, uploads ...upload) (string, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for key, values := range form {
+		for _, value := range values {
+			err := mw.WriteField(key, value)
+			assert.NilError(t, err)
+		}
+	}
+
+	for _, u := range uploads {
+		fw, err := mw.CreateFormFile("upload", u.name)
+		assert.NilError(t, err)
+		_, err = fw.Write(u.content)
+		assert.NilError(t, err)
+	}
+
+	err := mw.Close()
+	assert.NilError(t, err)
+
+	return mw.FormDataContentType(), buf.String()
+}
+
+func TestSnippetCreateUpload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		expires      string
+		uploads      []upload
+		wantCode     int
+		wantLocation string
+		wantBody     []string
+	}{
+		{
+			name:         "Valid upload",
+			expires:      "7",
+			uploads:      []upload{{"compose.yml", []byte("services: {}")}, {"Dockerfile", []byte("FROM scratch")}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			// Make the form invalid in some other way, so that it is
+			// re-displayed with the uploaded file filled in.
+			name:     "Title from file name",
+			expires:  "30",
+			uploads:  []upload{{"nginx.conf", []byte("server {}")}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{
+				`<input type='text' name='title' value="nginx.conf">`,
+				"<input type='text' name='files[0].name' value='nginx.conf'",
+				"<textarea name='files[0].content'>server {}</textarea>",
+			},
+		},
+		{
+			name:     "Binary file",
+			expires:  "7",
+			uploads:  []upload{{"logo.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"logo.png looks like a binary file"},
+		},
+		{
+			name:     "Not UTF-8",
+			expires:  "7",
+			uploads:  []upload{{"latin1.txt", []byte("caf\xe9")}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"latin1.txt is not UTF-8 text"},
+		},
+		{
+			name:     "Too large",
+			expires:  "7",
+			uploads:  []upload{{"big.txt", bytes.Repeat([]byte("a"), maxFileSize+1)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"big.txt is larger than the 64KB limit"},
+		},
+		{
+			name:     "Request too large",
+			expires:  "7",
+			uploads:  []upload{{"huge.txt", bytes.Repeat([]byte("a"), maxCreateRequestSize)}},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			form.Add("files[0].content", "")
+			form.Add("expires", tt.expires)
+
+			contentType, body := multipartForm(t, form, tt.uploads...)
+
+			code, headers, body := ts.do(t, http.MethodPost, "/snippet/create", map[string]string{"Content-Type": contentType}, body)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
+
+// Requests which don't say how long their body is (such as chunked uploads)
+// are only found to be too large once the body has been read, which can
+// happen while noSurf is looking for the CSRF token, or when the handler
+// parses the form if the token is sent in a header instead.
+func TestSnippetCreateChunkedTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name        string
+		tokenInForm bool
+	}{
+		{"Token in form", true},
+		{"Token in header", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("expires", "7")
+			headers := map[string]string{}
+
+			if tt.tokenInForm {
+				form.Add("csrf_token", csrfToken)
+			} else {
+				headers["X-CSRF-Token"] = csrfToken
+			}
+
+			contentType, body := multipartForm(t, form, upload{"huge.txt", bytes.Repeat([]byte("a"), maxCreateRequestSize)})
+			headers["Content-Type"] = contentType
+
+			// Hide the length of the body, so that it is sent chunked.
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/snippet/create", struct{ io.Reader }{strings.NewReader(body)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, http.StatusRequestEntityTooLarge)
+		})
+	}
+}
+
+func TestSnippetZip(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, body := ts.get(t, "/snippet/view/1.zip")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "application/zip")
+	assert.Equal(t, headers.Get("Content-Disposition"), `attachment; filename="snippet-1.zip"`)
+
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+	assert.Equal(t, len(zr.File), 1)
+	assert.Equal(t, zr.File[0].Name, "pond.txt")
+
+	f, err := zr.File[0].Open()
+	assert.NilError(t, err)
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "An old silent pond...")
+}
+
+func TestNormalizeFiles(t *testing.T) {
+	files := normalizeFiles([]models.File{
+		{Name: " main.go ", Content: "package main"},
+		{Name: "Dockerfile", Content: "FROM scratch"},
+		{Name: "notes.md", Language: "plaintext", Content: "# Notes"},
+		{Content: "hello"},
+	})
+
+	assert.Equal(t, files[0].Name, "main.go")
+	assert.Equal(t, files[0].Language, "go")
+	assert.Equal(t, files[1].Language, "dockerfile")
+	assert.Equal(t, files[2].Language, "plaintext")
+	assert.Equal(t, files[3].Name, "snippet4.txt")
+	assert.Equal(t, files[3].Language, "plaintext")
+}
+
+func TestWriteFilesText(t *testing.T) {
+	snippet := models.Snippet{
+		Content: "FROM scratch",
+		Files: []models.File{
+			{Name: "Dockerfile", Content: "FROM scratch"},
+			{Name: "run.sh", Content: "docker build .\n"},
+		},
+	}
+
+	var buf bytes.Buffer
+	writeFilesText(&buf, snippet)
+
+	assert.Equal(t, buf.String(), "==> Dockerfile <==\nFROM scratch\n\n==> run.sh <==\ndocker build .\n")
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"snippetbox.dkimhw.com/internal/models"
 	"snippetbox.dkimhw.com/internal/validator"
@@ -16,17 +22,100 @@ import (
 // must be exported in order to be read by the html/template package when
 // rendering the template.
 type snippetCreateForm struct {
-	Title               string     `form:"title"`
-	Content             string     `form:"content"`
+	Title string            `form:"title"`
+	Files []snippetFileForm `form:"files"` // decoded from fields like files[0].name
+	// Set by the buttons which add and remove files, which re-display the
+	// form instead of creating the snippet.
+	AddFile             bool       `form:"add_file"`
+	RemoveFile          *int       `form:"remove_file"`
 	Expires             int        `form:"expires"`
+	Tags                string     `form:"tags"` // comma-separated
 	validator.Validator `form:"-"` // The struct tag `form:"-"` tells the decoder to completely ignore a field during decoding.
 	// embedded struct; Embedding this means that our snippetCreateForm "inherits" all the
 	// fields and methods of our Validator struct (including the FieldErrors field).
 }
 
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Language string `form:"language"` // blank to detect it from the name
+	Content  string `form:"content"`
+}
+
+// files returns the files entered in the form.
+func (f snippetCreateForm) files() []models.File {
+	files := make([]models.File, len(f.Files))
+	for i, file := range f.Files {
+		files[i] = models.File{Name: file.Name, Language: file.Language, Content: file.Content}
+	}
+	return files
+}
+
+// addUploads adds uploaded files to the form, replacing any files which were
+// left empty. If no title was given, the first file's name is used.
+func (f *snippetCreateForm) addUploads(uploads []models.File) {
+	f.Files = slices.DeleteFunc(f.Files, func(file snippetFileForm) bool {
+		return strings.TrimSpace(file.Name) == "" && strings.TrimSpace(file.Content) == ""
+	})
+
+	for _, upload := range uploads {
+		f.Files = append(f.Files, snippetFileForm{Name: upload.Name, Content: upload.Content})
+	}
+
+	if strings.TrimSpace(f.Title) == "" {
+		f.Title = uploads[0].Name
+	}
+}
+
+// The validateSnippet function checks the fields of a new or updated snippet,
+// apart from its content, which is checked with validateFiles() or
+// validateContent(). It is shared by the HTML form handlers, the JSON API and
+// the paste endpoint, so that they all apply exactly the same rules.
+func validateSnippet(v *validator.Validator, title string, expires int) {
+	v.CheckField(validator.NotBlank(title), "title", "This field cannot be blank")
+	v.CheckField(validator.MaxChars(title, 100), "title", "This field cannot be more than 100 characters long")
+	v.CheckField(validator.PermittedValue(expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+}
+
+// validateContent checks the content of a snippet which is being created or
+// updated with a single file.
+func validateContent(v *validator.Validator, content string) {
+	v.CheckField(validator.NotBlank(content), "content", "This field cannot be blank")
+	v.CheckField(validator.MaxBytes(content, maxFileSize), "content", maxFileSizeMessage)
+}
+
+// The maximum number of tags a snippet can have.
+const maxTags = 5
+
+// validateTags checks a snippet's tags, which should already have been
+// normalized with parseTags() or normalizeTags().
+func validateTags(v *validator.Validator, tags []string) {
+	v.CheckField(len(tags) <= maxTags, "tags", fmt.Sprintf("This field cannot have more than %d tags", maxTags))
+	for _, tag := range tags {
+		v.CheckField(validator.MaxChars(tag, 30) && validator.Matches(tag, validator.TagRX), "tags", "Tags can only contain letters, numbers and hyphens, and be up to 30 characters long")
+	}
+}
+
+// parseTags splits a comma-separated list of tags and normalizes them.
+func parseTags(s string) []string {
+	return normalizeTags(strings.Split(s, ","))
+}
+
+// normalizeTags trims and lower-cases tags, dropping any which are blank or
+// duplicated.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
 // defined as a method against application struct
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -37,14 +126,38 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "home.tmpl", data)
 }
 
+// The snippetView handler serves a snippet as HTML, JSON or plain text. The
+// format is normally negotiated from the Accept header, but a ".json" or
+// ".txt" suffix on the ID (e.g. /snippet/view/1.json) overrides it, so that
+// each format also has a link of its own.
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
+	idParam, suffix := r.PathValue("id"), ""
+	if i := strings.IndexByte(idParam, '.'); i >= 0 {
+		idParam, suffix = idParam[:i], idParam[i:]
+	}
+
+	var format string
+	if suffix != "" {
+		var ok bool
+		format, ok = formatSuffixes[suffix]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+	} else {
+		// The response depends on the Accept header, so caches need to
+		// know to take it into account.
+		w.Header().Add("Vary", "Accept")
+		format = negotiate(r, mediaTypeHTML, mediaTypeJSON, mediaTypeText, mediaTypeZip)
+	}
+
+	id, err := strconv.Atoi(idParam)
 	if err != nil || id < 1 {
 		http.NotFound(w, r)
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -54,10 +167,22 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	app.countView(r, snippet.ID)
 
-	app.render(w, r, http.StatusOK, "view.tmpl", data)
+	switch format {
+	case mediaTypeJSON:
+		err = app.writeJSON(w, http.StatusOK, envelope{"snippet": snippet})
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+	case mediaTypeText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeFilesText(w, snippet)
+	case mediaTypeZip:
+		app.serveZip(w, r, snippet)
+	default:
+		app.renderSnippetPage(w, r, http.StatusOK, snippet, commentForm{})
+	}
 }
 
 func (app *application) about(w http.ResponseWriter, r *http.Request) {
@@ -69,10 +194,13 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 
 	// Initialize a new createSnippetForm instance and pass it to the template.
-	// Set the initial value for the snippet expiry to 365 days.
+	// Set the initial value for the snippet expiry to 365 days, and start
+	// with one empty file.
 	data.Form = snippetCreateForm{
+		Files:   []snippetFileForm{{}},
 		Expires: 365,
 	}
+	data.Languages = languages
 
 	app.render(w, r, http.StatusOK, "create.tmpl", data)
 }
@@ -82,15 +210,47 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	var form snippetCreateForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		// If there is a problem, we return a 400 Bad Request response to the client.
-		app.clientError(w, http.StatusBadRequest)
+		// If the body was longer than limitRequestBody allows, say so.
+		// Otherwise we return a 400 Bad Request response to the client.
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		} else {
+			app.clientError(w, http.StatusBadRequest)
+		}
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	// Uploaded files are added to the form as if they had been typed in, so
+	// that they're kept if the form is re-displayed.
+	if uploads := readUploads(&form.Validator, r); len(uploads) > 0 {
+		form.addUploads(uploads)
+	}
+
+	// The "Add file" and "Remove" buttons submit the form too. Rather than
+	// creating the snippet, they re-display the form with the files changed,
+	// so the form works without any JavaScript.
+	if form.AddFile || form.RemoveFile != nil {
+		if form.AddFile && len(form.Files) < maxFiles {
+			form.Files = append(form.Files, snippetFileForm{})
+		}
+		if i := form.RemoveFile; i != nil && *i >= 0 && *i < len(form.Files) && len(form.Files) > 1 {
+			form.Files = slices.Delete(form.Files, *i, *i+1)
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.Languages = languages
+		app.render(w, r, http.StatusOK, "create.tmpl", data)
+		return
+	}
+
+	tags := parseTags(form.Tags)
+	files := normalizeFiles(form.files())
+
+	validateSnippet(&form.Validator, form.Title, form.Expires)
+	validateFiles(&form.Validator, files)
+	validateTags(&form.Validator, tags)
 
 	// If there are any validation errors, then re-display the create.tmpl template,
 	// passing in the snippetCreateForm instance as dynamic data in the Form
@@ -99,16 +259,22 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
+		data.Languages = languages
 		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl", data)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	id, err := app.snippets.Insert(r.Context(), userID, form.Title, files, form.Expires, tags)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	app.metrics.snippetsCreated.Inc()
+	app.notifySnippetCreated(r, id)
+
 	// Use the Put() method to add a string value ("Snippet successfully
 	// created!") and the corresponding key ("flash") to the session data.
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
@@ -116,6 +282,72 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+// snippetForkPost copies a snippet into a new snippet belonging to the current
+// user. Every unexpired snippet can be viewed by anyone, so anyone who is
+// logged in can fork it, including its author.
+func (app *application) snippetForkPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Forks get the same expiry as the default on the create form, rather
+	// than the remaining lifetime of the snippet they were forked from.
+	const forkExpires = 365
+
+	forkID, err := app.snippets.Fork(r.Context(), id, app.authenticatedUserID(r), forkExpires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+	app.notifySnippetCreated(r, forkID)
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully forked!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", forkID), http.StatusSeeOther)
+}
+
+// snippetStarPost stars a snippet for the current user. Starring a snippet
+// twice has the same effect as starring it once, so the form can safely be
+// resubmitted.
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	app.updateStar(w, r, app.stars.Star)
+}
+
+// snippetUnstarPost removes the current user's star from a snippet, and is
+// idempotent in the same way as snippetStarPost.
+func (app *application) snippetUnstarPost(w http.ResponseWriter, r *http.Request) {
+	app.updateStar(w, r, app.stars.Unstar)
+}
+
+// updateStar calls the star model method for the snippet in the URL, and sends
+// the user back to the snippet.
+func (app *application) updateStar(w http.ResponseWriter, r *http.Request, update func(ctx context.Context, userID, snippetID int) error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = update(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
 // Create a new userSignupForm struct
 type userSignupForm struct {
 	Name                string `form:"name"`
@@ -158,7 +390,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 
 	// Try to create a new user record in the database. If the email already
 	// exists then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
@@ -173,8 +405,18 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.metrics.signups.Inc()
+
+	// Email the user a link to verify their address. The account has been
+	// created by now, so if the email can't be sent we just log the error:
+	// the user can ask for another one once they've logged in.
+	_, err = app.sendVerificationEmail(r, models.User{ID: id, Name: form.Name, Email: form.Email})
+	if err != nil {
+		app.requestLogger(r).Error("sending verification email", slog.String("error", err.Error()))
+	}
+
 	// Otherwise add a confirmation flash msg to the session confirming that their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. We've emailed you a link to verify your address. Please log in.")
 
 	// And redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -218,9 +460,10 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check whether the credentials are valid - if not, add a generic non-field error msg
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	id, err := app.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.metrics.logins.WithLabelValues("failure").Inc()
 			form.AddNonFieldError("Email or password is incorrect")
 
 			data := app.newTemplateData(r)
@@ -239,8 +482,41 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add the ID of the current user to the session
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Users who have enabled two-factor authentication aren't logged in yet.
+	// The session only records that they have entered their password, and
+	// they are asked for a code next.
+	if user.TwoFactorEnabled {
+		app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+		app.sessionManager.Put(r.Context(), "pendingTwoFactorUserID", id)
+		app.sessionManager.Put(r.Context(), "pendingTwoFactorExpires", time.Now().Add(twoFactorLoginTTL).Unix())
+		app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	app.logIn(w, r, user)
+}
+
+// logIn logs the user in to the current session, which must already have
+// been renewed, and redirects them to where they were going.
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, user models.User) {
+	app.metrics.logins.WithLabelValues("success").Inc()
+
+	// Add the ID of the current user to the session, along with their
+	// session version, which authenticate() checks so that all of the user's
+	// sessions can be ended at once. Any TOTP secret which was being set up
+	// in the session before is discarded, since it may have been someone
+	// else's.
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
+	app.sessionManager.Put(r.Context(), "sessionVersion", user.SessionVersion)
+	app.sessionManager.Remove(r.Context(), "totpEnrollmentSecret")
 
 	// Use the PopString method to retrieve and remove a value from the session
 	// data in one step. If no matching key exists this will return the empty
@@ -265,6 +541,7 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 
 	// Remove the authenticatedUserID from the session data so that the user is logged out.
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "totpEnrollmentSecret")
 
 	// Add a flash message to the session to confirm to the user that they have been logged out.
 	app.sessionManager.Put(r.Context(), "flash", "You have been logged out successfully!")
@@ -277,9 +554,108 @@ func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// The healthz handler reports whether the process is alive. It deliberately
+// doesn't check any dependencies: if the database is down, restarting the
+// application won't help.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// The readyz handler reports whether the application is ready to serve
+// traffic, with the result of each individual check. Load balancers should
+// stop sending requests to the instance while it returns 503.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]checkResult{}
+	ready := true
+
+	record := func(name string, err error) {
+		if err != nil {
+			checks[name] = checkResult{Status: "fail", Error: err.Error()}
+			ready = false
+			return
+		}
+		checks[name] = checkResult{Status: "ok"}
+	}
+
+	// Once the application has started shutting down, report as not-ready so
+	// that load balancers drain the instance before it stops.
+	if app.shuttingDown.Load() {
+		record("shutdown", errShuttingDown)
+	}
+
+	if len(app.templateCache) == 0 {
+		record("templates", errNoTemplates)
+	} else {
+		record("templates", nil)
+	}
+
+	for name, check := range app.readinessChecks {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+		record(name, check(ctx))
+		cancel()
+	}
+
+	status, statusText := http.StatusOK, "ready"
+	if !ready {
+		status, statusText = http.StatusServiceUnavailable, "not ready"
+	}
+
+	// Make sure that no intermediary caches a stale readiness result.
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, status, readinessReport{Status: statusText, Checks: checks})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+type logLevelPayload struct {
+	Level string `json:"level"`
+}
+
+// The logLevelView handler returns the current minimum log level.
+func (app *application) logLevelView(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, logLevelPayload{Level: app.logLevel.Level().String()})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// The logLevelUpdate handler changes the minimum log level without restarting
+// the application, e.g.
+//
+//	curl -X PUT -H "Authorization: Bearer $ADMIN_TOKEN" -d '{"level":"debug"}' localhost:4001/log-level
+func (app *application) logLevelUpdate(w http.ResponseWriter, r *http.Request) {
+	var payload logLevelPayload
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&payload)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(payload.Level))
+	if err != nil {
+		app.clientError(w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+
+	app.requestLogger(r).Warn("log level changed", "from", previous.String(), "to", level.String())
+
+	err = app.writeJSON(w, http.StatusOK, logLevelPayload{Level: level.String()})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
 func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	user, err := app.users.Get(userID)
+	user, err := app.users.Get(r.Context(), userID)
 
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
@@ -303,6 +679,20 @@ type accountPasswordUpdateForm struct {
 	validator.Validator     `form:"-"`
 }
 
+// accountStars lists the snippets which the current user has starred.
+func (app *application) accountStars(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.stars.ByUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "stars.tmpl", data)
+}
+
 func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = accountPasswordUpdateForm{}
@@ -335,7 +725,7 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 
 	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
 
-	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	err = app.users.PasswordUpdate(r.Context(), userID, form.CurrentPassword, form.NewPassword)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			form.AddFieldError("currentPassword", "Current password is incorrect")
@@ -354,3 +744,267 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 
 	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
+
+type tokenCreateForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	Expires             int    `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.tokens.GetForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tokens = tokens
+	data.Form = tokenCreateForm{
+		Scope:   models.ScopeRead,
+		Expires: 30,
+	}
+
+	app.render(w, r, http.StatusOK, "tokens.tmpl", data)
+}
+
+func (app *application) accountTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Scope, models.ScopeRead, models.ScopeWrite, models.ScopeAdmin), "scope", "This field must equal read, write or admin")
+	form.CheckField(validator.PermittedValue(form.Expires, 7, 30, 90, 365), "expires", "This field must equal 7, 30, 90 or 365")
+
+	userID := app.authenticatedUserID(r)
+
+	if !form.Valid() {
+		tokens, err := app.tokens.GetForUser(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Tokens = tokens
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "tokens.tmpl", data)
+		return
+	}
+
+	token, err := app.tokens.Insert(r.Context(), userID, form.Name, form.Scope, time.Duration(form.Expires)*24*time.Hour)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	tokens, err := app.tokens.GetForUser(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Render the page directly rather than redirecting, because this is the
+	// only time the plain-text token is available and we don't want to store
+	// it in the session data to carry it across a redirect.
+	data := app.newTemplateData(r)
+	data.Tokens = tokens
+	data.NewToken = token
+	data.Form = tokenCreateForm{
+		Scope:   models.ScopeRead,
+		Expires: 30,
+	}
+	data.Flash = "Your new token has been created. Copy it now, as you won't be able to see it again!"
+
+	app.render(w, r, http.StatusOK, "tokens.tmpl", data)
+}
+
+func (app *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.tokens.Delete(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your token has been revoked.")
+
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+type webhookCreateForm struct {
+	URL                 string   `form:"url"`
+	Events              []string `form:"events"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.webhooks.GetForUser(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhooks = webhooks
+	data.Form = webhookCreateForm{
+		Events: models.WebhookEvents,
+	}
+
+	app.render(w, r, http.StatusOK, "webhooks.tmpl", data)
+}
+
+func (app *application) accountWebhookCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form webhookCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.URL, 2048), "url", "This field cannot be more than 2048 characters long")
+	form.CheckField(validator.WebURL(form.URL), "url", "This field must be a valid http or https URL")
+	form.CheckField(len(form.Events) > 0, "events", "You must choose at least one event")
+	form.CheckField(validator.PermittedValues(form.Events, models.WebhookEvents...), "events", "This field contains an unknown event")
+
+	userID := app.authenticatedUserID(r)
+
+	if !form.Valid() {
+		webhooks, err := app.webhooks.GetForUser(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Webhooks = webhooks
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "webhooks.tmpl", data)
+		return
+	}
+
+	webhook, err := app.webhooks.Insert(r.Context(), userID, form.URL, form.Events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your webhook has been created.")
+
+	http.Redirect(w, r, fmt.Sprintf("/account/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+// The accountWebhookView handler shows a webhook's settings, including the
+// secret for checking signatures, and the log of its recent deliveries.
+func (app *application) accountWebhookView(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := app.webhooks.Deliveries(r.Context(), webhook.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhook = webhook
+	data.Deliveries = deliveries
+
+	app.render(w, r, http.StatusOK, "webhook.tmpl", data)
+}
+
+// The accountWebhookTestPost handler queues a ping event for the webhook, so
+// that users can check their receiver is working without having to change
+// any snippets.
+func (app *application) accountWebhookTestPost(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readOwnWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event:     models.EventPing,
+		Created:   time.Now().UTC(),
+		WebhookID: webhook.ID,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.webhooks.EnqueueOne(r.Context(), webhook.ID, models.EventPing, payload)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.webhookDispatcher.wake()
+
+	app.sessionManager.Put(r.Context(), "flash", "A test event has been sent. Refresh the page to see the result.")
+
+	http.Redirect(w, r, fmt.Sprintf("/account/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+func (app *application) accountWebhookDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.webhooks.Delete(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your webhook has been deleted.")
+
+	http.Redirect(w, r, "/account/webhooks", http.StatusSeeOther)
+}
+
+// The readOwnWebhook helper fetches the authenticated user's webhook which is
+// identified by the {id} path value. If that fails it sends the appropriate
+// error response and returns false.
+func (app *application) readOwnWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return models.Webhook{}, false
+	}
+
+	webhook, err := app.webhooks.Get(r.Context(), app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Webhook{}, false
+	}
+
+	return webhook, true
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/models/mocks"
 )
 
 func TestPing(t *testing.T) {
@@ -20,6 +29,149 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, body, "OK")
 }
 
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		checkErr     error
+		shuttingDown bool
+		wantCode     int
+		wantBody     string
+	}{
+		{
+			name:     "Ready",
+			wantCode: http.StatusOK,
+			wantBody: `"database":{"status":"ok"}`,
+		},
+		{
+			name:     "Database down",
+			checkErr: errors.New("connection refused"),
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `"database":{"status":"fail","error":"connection refused"}`,
+		},
+		{
+			name:         "Shutting down",
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantBody:     `"shutdown":{"status":"fail","error":"server is shutting down"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.readinessChecks = map[string]readinessCheck{
+				"database": func(ctx context.Context) error { return tt.checkErr },
+			}
+			app.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, headers, body := ts.get(t, "/readyz")
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Content-Type"), "application/json")
+			assert.StringContains(t, body, tt.wantBody)
+			assert.StringContains(t, body, `"templates":{"status":"ok"}`)
+		})
+	}
+}
+
+func TestLogLevelUpdate(t *testing.T) {
+	app := newTestApplication(t)
+
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	tests := []struct {
+		name      string
+		token     string
+		body      string
+		wantCode  int
+		wantLevel slog.Level
+	}{
+		{
+			name:      "Valid level",
+			token:     "admin-token",
+			body:      `{"level":"debug"}`,
+			wantCode:  http.StatusOK,
+			wantLevel: slog.LevelDebug,
+		},
+		{
+			name:      "Missing token",
+			body:      `{"level":"error"}`,
+			wantCode:  http.StatusUnauthorized,
+			wantLevel: slog.LevelInfo,
+		},
+		{
+			name:      "Wrong token",
+			token:     "wrong-token",
+			body:      `{"level":"error"}`,
+			wantCode:  http.StatusUnauthorized,
+			wantLevel: slog.LevelInfo,
+		},
+		{
+			name:      "Unknown level",
+			token:     "admin-token",
+			body:      `{"level":"loud"}`,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantLevel: slog.LevelInfo,
+		},
+		{
+			name:      "Malformed body",
+			token:     "admin-token",
+			body:      `level=debug`,
+			wantCode:  http.StatusBadRequest,
+			wantLevel: slog.LevelInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.logLevel.Set(slog.LevelInfo)
+
+			req, err := http.NewRequest(http.MethodPut, admin.URL+"/log-level", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rs, err := admin.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+			assert.Equal(t, app.logLevel.Level(), tt.wantLevel)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	// Make a couple of requests to the public routes so that there is
+	// something to report.
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+
+	code, _, body := admin.get(t, "/metrics")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `snippetbox_http_requests_total{code="200",route="GET /snippet/view/{id}"} 1`)
+	assert.StringContains(t, body, `snippetbox_http_requests_total{code="404",route="GET /snippet/view/{id}"} 1`)
+	assert.StringContains(t, body, `snippetbox_template_render_duration_seconds_count{page="view.tmpl"} 1`)
+}
+
 func TestSnippetView(t *testing.T) {
 	// Create a new instance of our application struct which uses the mocked deps.
 	app := newTestApplication(t)
@@ -81,6 +233,359 @@ func TestSnippetView(t *testing.T) {
 	}
 }
 
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.Equal(t, strings.Contains(body, "Fork this snippet"), false)
+		// The forks of the snippet are listed for everyone.
+		assert.StringContains(t, body, "<a href='/snippet/view/2'>An old silent pond (remix)</a>")
+
+		_, _, body = ts.get(t, "/user/login")
+
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, headers, _ := ts.postForm(t, "/snippet/fork/1", form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<form class='fork' action='/snippet/fork/1' method='POST'>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Valid ID",
+			urlPath:      "/snippet/fork/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/fork/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/snippet/fork/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestSnippetStar(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	// Alice has already starred the mock snippet.
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<span title='Stars'>&#9733; 1</span>")
+	assert.StringContains(t, body, "<form class='star' action='/snippet/unstar/1' method='POST'>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Star",
+			urlPath:      "/snippet/star/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:         "Unstar",
+			urlPath:      "/snippet/unstar/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/star/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/snippet/unstar/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	t.Run("Without CSRF token", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, "/snippet/star/1", url.Values{})
+
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
+
+// starCountSnippetModel is the mock snippet model, with the star count of the
+// mock snippet taken from stars, which starCountStarModel changes. The mock
+// snippet expires straight away, which would stop it from being cached, so it
+// is given a later expiry time.
+type starCountSnippetModel struct {
+	mocks.SnippetModel
+	stars *atomic.Int64
+}
+
+func (m *starCountSnippetModel) Get(ctx context.Context, id int) (models.Snippet, error) {
+	s, err := m.SnippetModel.Get(ctx, id)
+	s.Stars = int(m.stars.Load())
+	s.Expires = time.Now().Add(time.Hour)
+	return s, err
+}
+
+func (m *starCountSnippetModel) Latest(ctx context.Context) ([]models.Snippet, error) {
+	snippets, err := m.SnippetModel.Latest(ctx)
+	for i := range snippets {
+		snippets[i].Stars = int(m.stars.Load())
+		snippets[i].Expires = time.Now().Add(time.Hour)
+	}
+	return snippets, err
+}
+
+type starCountStarModel struct {
+	mocks.StarModel
+	stars *atomic.Int64
+}
+
+func (m *starCountStarModel) Star(ctx context.Context, userID, snippetID int) error {
+	err := m.StarModel.Star(ctx, userID, snippetID)
+	if err == nil {
+		m.stars.Add(1)
+	}
+	return err
+}
+
+func (m *starCountStarModel) Unstar(ctx context.Context, userID, snippetID int) error {
+	err := m.StarModel.Unstar(ctx, userID, snippetID)
+	if err == nil {
+		m.stars.Add(-1)
+	}
+	return err
+}
+
+// With caching switched on, the new star count is shown as soon as a snippet
+// has been starred or unstarred.
+func TestSnippetStarCached(t *testing.T) {
+	app := newTestApplication(t)
+
+	stars := &atomic.Int64{}
+	stars.Store(1)
+
+	cache := models.NewCachedSnippetModel(&starCountSnippetModel{stars: stars}, time.Hour)
+	app.snippets = cache
+	app.stars = cache.StarModel(&starCountStarModel{stars: stars})
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<span title='Stars'>&#9733; 1</span>")
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, body, "<td>1</td>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		wantStars string
+	}{
+		{"Star", "/snippet/star/1", "2"},
+		{"Unstar", "/snippet/unstar/1", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			_, _, body := ts.get(t, "/snippet/view/1")
+			assert.StringContains(t, body, "<span title='Stars'>&#9733; "+tt.wantStars+"</span>")
+
+			_, _, body = ts.get(t, "/")
+			assert.StringContains(t, body, "<td>"+tt.wantStars+"</td>")
+		})
+	}
+}
+
+func TestAccountStars(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/account/stars")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/stars")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<td><a href='/snippet/view/1'>An old silent pond</a></td>")
+}
+
+func TestSnippetViewFormats(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantVary        bool
+		wantBody        string
+	}{
+		{
+			name:            "No Accept header",
+			urlPath:         "/snippet/view/1",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantVary:        true,
+			wantBody:        "<code class='language-plaintext'>An old silent pond...</code>",
+		},
+		{
+			name:            "Browser",
+			urlPath:         "/snippet/view/1",
+			accept:          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantVary:        true,
+		},
+		{
+			name:            "Accept JSON",
+			urlPath:         "/snippet/view/1",
+			accept:          "application/json",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantVary:        true,
+			wantBody:        `"title":"An old silent pond"`,
+		},
+		{
+			name:            "Accept text",
+			urlPath:         "/snippet/view/1",
+			accept:          "text/plain",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantVary:        true,
+			wantBody:        "An old silent pond...",
+		},
+		{
+			name:            "Quality values",
+			urlPath:         "/snippet/view/1",
+			accept:          "text/html;q=0.5, application/json",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantVary:        true,
+		},
+		{
+			name:            "Unacceptable",
+			urlPath:         "/snippet/view/1",
+			accept:          "image/png",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantVary:        true,
+		},
+		{
+			name:            "JSON suffix",
+			urlPath:         "/snippet/view/1.json",
+			accept:          "text/html",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `"content":"An old silent pond..."`,
+		},
+		{
+			name:            "Text suffix",
+			urlPath:         "/snippet/view/1.txt",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "An old silent pond...",
+		},
+		{
+			name:     "Unknown suffix",
+			urlPath:  "/snippet/view/1.xml",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent ID with suffix",
+			urlPath:  "/snippet/view/2.json",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.accept != "" {
+				headers["Accept"] = tt.accept
+			}
+
+			code, header, body := ts.do(t, http.MethodGet, tt.urlPath, headers, "")
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantContentType != "" {
+				assert.Equal(t, header.Get("Content-Type"), tt.wantContentType)
+			}
+			if code == http.StatusOK {
+				assert.Equal(t, slices.Contains(header.Values("Vary"), "Accept"), tt.wantVary)
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
 func TestUserSignup(t *testing.T) {
 	// Create the application struct containing our mocked dependencies
 	app := newTestApplication(t)
@@ -229,6 +734,87 @@ func TestSnippetCreate(t *testing.T) {
 		code, _, body := ts.get(t, "/snippet/create")
 
 		assert.Equal(t, code, http.StatusOK)
-		assert.StringContains(t, body, "<form action='/snippet/create' method='POST'>")
+		assert.StringContains(t, body, "<form action='/snippet/create' method='POST' enctype='multipart/form-data'>")
 	})
 }
+
+func TestAccountTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<form action='/account/tokens/3/revoke' method='POST'>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		tokName  string
+		scope    string
+		expires  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid submission",
+			tokName:  "CI",
+			scope:    "write",
+			expires:  "30",
+			wantCode: http.StatusOK,
+			wantBody: "<code>sbx_NEWTOKEN</code>",
+		},
+		{
+			name:     "Invalid scope",
+			tokName:  "CI",
+			scope:    "root",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal read, write or admin",
+		},
+		{
+			name:     "Blank name",
+			tokName:  "",
+			scope:    "read",
+			expires:  "7",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokName)
+			form.Add("scope", tt.scope)
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/tokens", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "Empty", input: "", want: ""},
+		{name: "Single", input: "go", want: "go"},
+		{name: "Whitespace and case", input: " Go ,  Shell ", want: "go,shell"},
+		{name: "Duplicates and blanks", input: "go,,GO, ,sql", want: "go,sql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, strings.Join(parseTags(tt.input), ","), tt.want)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// Each readiness check gets this long to complete before it is reported as
+// failed.
+const readinessCheckTimeout = 2 * time.Second
+
+var (
+	errShuttingDown = errors.New("server is shutting down")
+	errNoTemplates  = errors.New("template cache is empty")
+)
+
+// A readinessCheck reports whether a dependency of the application is
+// available. It should return promptly once ctx is done.
+type readinessCheck func(ctx context.Context) error
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// databaseCheck pings the database connection pool.
+func databaseCheck(db *sql.DB) readinessCheck {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// sessionStoreCheck looks up a token which doesn't exist in the session
+// store. A missing session isn't an error, so this only fails if the store
+// itself can't be reached.
+func sessionStoreCheck(store scs.Store) readinessCheck {
+	return func(ctx context.Context) error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, _, err := store.Find("readiness-probe")
+			errCh <- err
+		}()
+
+		select {
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
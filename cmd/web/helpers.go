@@ -2,9 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"mime"
 	"net/http"
+	"runtime"
 	"time"
 
 	"github.com/go-playground/form/v4"
@@ -14,14 +20,32 @@ import (
 // The serverError helper writes a log entry at Error level (including the request
 // method and URI as attributes), then sends a generic 500 Internal Server Error
 // response to the user.
+//
+// The request ID is included in the response so that users can quote it when
+// reporting a problem, and we can find the matching log entry.
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	var (
 		method = r.Method
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	// Build the log record by hand so that its source location points at
+	// whoever called serverError(), rather than at this helper.
+	logger := app.requestLogger(r)
+	if logger.Enabled(r.Context(), slog.LevelError) {
+		var pcs [1]uintptr
+		runtime.Callers(2, pcs[:])
+
+		record := slog.NewRecord(time.Now(), slog.LevelError, err.Error(), pcs[0])
+		record.Add("method", method, "uri", uri)
+		logger.Handler().Handle(r.Context(), record)
+	}
+
+	message := http.StatusText(http.StatusInternalServerError)
+	if id := requestID(r); id != "" {
+		message = fmt.Sprintf("%s\nRequest ID: %s", message, id)
+	}
+	http.Error(w, message, http.StatusInternalServerError)
 }
 
 func (app *application) clientError(w http.ResponseWriter, status int) {
@@ -35,6 +59,24 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 // 	http.Error(w, http.StatusText(status), status)
 // }
 
+// The writeJSON helper encodes data as JSON and sends it with the given status
+// code and the appropriate Content-Type header.
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// Append a newline to make it easier to view in terminal applications.
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
 func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) {
 	ts, ok := app.templateCache[page] // check the cache if the page exists
 	if !ok {
@@ -43,11 +85,17 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 		return
 	}
 
+	_, span := tracer.Start(r.Context(), "render "+page)
+	start := time.Now()
 	buf := new(bytes.Buffer)                     // initalize a new buffer
 	err := ts.ExecuteTemplate(buf, "base", data) // write template to buffer
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	recordSpanError(span, err)
+	span.End()
 	if err != nil {
 		// if there is an error writing to buffer, call serverError()
 		app.serverError(w, r, err)
+		return
 	}
 
 	// If the template is written to the buffer without any errors, we are safe
@@ -69,13 +117,22 @@ func (app *application) newTemplateData(r *http.Request) templateData {
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r), // Add the CSRF token.
+		Feeds:           feedLinks("Latest snippets", "/feed"),
 	}
 }
 
 // dst is the target destination that we want to decode the form data into
 func (app *application) decodePostForm(r *http.Request, dst any) error {
-	// Call ParseForm() on the request
-	err := r.ParseForm()
+	// Forms with file inputs are sent as multipart/form-data rather than
+	// URL-encoded. ParseMultipartForm() fills in r.PostForm too, so they can be
+	// decoded in the same way; the files are left in r.MultipartForm. Otherwise
+	// call ParseForm() on the request.
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		err = r.ParseMultipartForm(maxMultipartMemory)
+	} else {
+		err = r.ParseForm()
+	}
 	if err != nil {
 		return err
 	}
@@ -101,6 +158,50 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// The requestLogger helper returns the request-scoped logger stored in the
+// context by the requestID middleware, falling back to the application logger.
+func (app *application) requestLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*slog.Logger)
+	if !ok {
+		return app.logger
+	}
+
+	return logger
+}
+
+// Return the ID of the current request, or the empty string if it has none.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// Generate a random 128-bit request ID, encoded as hex.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Check that a client-supplied request ID is reasonably short and only uses
+// characters which are safe to write to logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 // Return true if current request is form an authenticated user - else false.
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
@@ -111,3 +212,28 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 }
+
+// Return the ID of the authenticated user, or 0 if the request isn't
+// authenticated. This works for both session and token authentication.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, _ := r.Context().Value(authenticatedUserIDContextKey).(int)
+	return id
+}
+
+// background runs fn in a goroutine which the application waits for before it
+// shuts down. Any panic in fn is logged rather than crashing the server.
+func (app *application) background(fn func()) {
+	app.backgroundTasks.Add(1)
+
+	go func() {
+		defer app.backgroundTasks.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// newLogger creates the application logger. The format must be "text" or
+// "json", and the minimum level is read from the given slog.LevelVar on every
+// call, which means that it can be changed while the application is running.
+func newLogger(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	var plain, withSource slog.Handler
+
+	switch format {
+	case "text":
+		plain = slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+		withSource = slog.NewTextHandler(w, &slog.HandlerOptions{Level: level, AddSource: true})
+	case "json":
+		plain = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+		withSource = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, AddSource: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(&errorSourceHandler{plain: plain, withSource: withSource}), nil
+}
+
+// The errorSourceHandler type only includes the source code location in Error
+// level entries. Adding it to every entry would make the request logs a lot
+// noisier without telling us anything useful.
+type errorSourceHandler struct {
+	plain      slog.Handler
+	withSource slog.Handler
+}
+
+func (h *errorSourceHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.plain.Enabled(ctx, level)
+}
+
+func (h *errorSourceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		return h.withSource.Handle(ctx, r)
+	}
+	return h.plain.Handle(ctx, r)
+}
+
+func (h *errorSourceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorSourceHandler{
+		plain:      h.plain.WithAttrs(attrs),
+		withSource: h.withSource.WithAttrs(attrs),
+	}
+}
+
+func (h *errorSourceHandler) WithGroup(name string) slog.Handler {
+	return &errorSourceHandler{
+		plain:      h.plain.WithGroup(name),
+		withSource: h.withSource.WithGroup(name),
+	}
+}
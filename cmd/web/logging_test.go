@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+
+	logger, err := newLogger(&buf, "json", level)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Info entries are written without a source location.
+	logger.Info("info message")
+	assert.StringContains(t, buf.String(), `"msg":"info message"`)
+	assert.Equal(t, bytes.Contains(buf.Bytes(), []byte(`"source"`)), false)
+
+	// Error entries include it.
+	buf.Reset()
+	logger.With("request_id", "abc").Error("error message")
+	assert.StringContains(t, buf.String(), `"source":{`)
+	assert.StringContains(t, buf.String(), `"request_id":"abc"`)
+
+	// Raising the level at runtime suppresses lower level entries.
+	buf.Reset()
+	level.Set(slog.LevelWarn)
+	logger.Info("suppressed")
+	assert.Equal(t, buf.Len(), 0)
+
+	_, err = newLogger(&buf, "xml", level)
+	assert.Equal(t, err != nil, true)
+}
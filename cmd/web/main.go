@@ -8,14 +8,22 @@ package main
 */
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	// Not using anything in this package but need the `init()` function to run so
@@ -25,6 +33,8 @@ import (
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"snippetbox.dkimhw.com/internal/mailer"
 	"snippetbox.dkimhw.com/internal/models"
 )
 
@@ -34,9 +44,41 @@ type application struct {
 	logger         *slog.Logger
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	passwordResets models.PasswordResetModelInterface
+	twoFactor      models.TwoFactorModelInterface
+	tokens         models.TokenModelInterface
+	webhooks       models.WebhookModelInterface
+	stars          models.StarModelInterface
+	comments       models.CommentModelInterface
+	collections    models.CollectionModelInterface
+	views          models.ViewModelInterface
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	metrics        *metrics
+	logLevel       *slog.LevelVar
+	adminToken     string
+	mailer         mailer.Mailer
+	signer         signer
+	pasteLimiters  pasteLimiters
+	// Limits how many two-factor codes each user can try when logging in.
+	twoFactorLimiter *rateLimiter
+	// The public URL of the site, for absolute links in emails, feeds, embeds
+	// and paste responses. The Host header of the request can't be trusted
+	// for this.
+	baseURL string
+	// Delivers the events queued for users' webhooks in the background.
+	webhookDispatcher *webhookDispatcher
+	// Buffers snippet view counts and writes them in the background.
+	viewCounter *viewCounter
+	// Origins other than our own which may embed snippets in a frame.
+	embedOrigins []string
+	// Dependencies checked by the /readyz endpoint, keyed by name.
+	readinessChecks map[string]readinessCheck
+	// Set once a graceful shutdown has begun, which makes /readyz fail.
+	shuttingDown atomic.Bool
+	// Tasks started by background(), which are waited for on shutdown.
+	backgroundTasks sync.WaitGroup
 }
 
 func main() {
@@ -46,6 +88,43 @@ func main() {
 	// and some short help text explaining what the flag controls
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	dsn := flag.String("dsn", fmt.Sprintf("web:%s@/snippetbox?parseTime=true", os.Getenv("DB_PASSWORD")), "MySQL data source name")
+	// Caching of snippet queries is switched off unless a positive TTL is given.
+	cacheTTL := flag.Duration("cache-ttl", 0, "Maximum time to cache snippet queries in memory (0 disables caching)")
+	// How long to keep serving requests after /readyz starts failing, so that
+	// load balancers have time to notice and drain the instance.
+	drainDelay := flag.Duration("drain-delay", 5*time.Second, "Time to wait between failing readiness checks and shutting down")
+	// The admin listener serves operational endpoints such as /metrics. It is
+	// bound to the loopback interface by default so it isn't publicly exposed.
+	adminAddr := flag.String("admin-addr", "localhost:4001", "HTTP network address for the admin listener (empty disables it)")
+	// Endpoints on the admin listener which change the application's behaviour
+	// require this token. They are disabled if it is empty.
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "Bearer token for admin endpoints")
+	// Other sites (such as an internal wiki) can only embed snippets if their
+	// origin is listed here.
+	embedOrigins := flag.String("embed-origins", "", "Comma-separated list of origins allowed to embed snippets, e.g. https://wiki.example.com")
+	// Webhooks can't be pointed at loopback or private network addresses
+	// unless this is set, which is mostly useful for local development.
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "Allow webhooks to be delivered to loopback and private network addresses")
+	// Links in emails are signed with this key. If it isn't set, a random
+	// one is used, and links stop working when the application restarts.
+	secretKey := flag.String("secret-key", os.Getenv("SECRET_KEY"), "Key for signing the links in emails")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Public URL of the site, used in links in emails, feeds and embeds")
+	// Emails are written to files in the outbox directory unless an SMTP
+	// server is configured.
+	mailerType := flag.String("mailer", "file", "How to send email (file|smtp)")
+	outboxDir := flag.String("outbox-dir", "./tmp/outbox", "Directory to write emails to when using the file mailer")
+	smtpHost := flag.String("smtp-host", "localhost", "SMTP server host")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	mailSender := flag.String("mail-sender", "Snippetbox <no-reply@snippetbox.dkimhw.com>", "Sender address for emails")
+	traceExporter := flag.String("trace-exporter", "none", "OpenTelemetry trace exporter (none|stdout|file|otlp)")
+	traceFile := flag.String("trace-file", "traces.json", "File to write spans to when using the file trace exporter")
+	logFormat := flag.String("log-format", "text", "Log output format (text|json)")
+	// Use a slog.LevelVar to hold the minimum log level, so that it can be
+	// changed at runtime through the admin listener.
+	logLevel := new(slog.LevelVar)
+	flag.TextVar(logLevel, "log-level", logLevel, "Minimum log level (debug|info|warn|error)")
 	// Importantly, we use the flag.Parse() function to parse the command-line flag.
 	// This reads in the command-line flag value and assigns it to the addr
 	// variable. You need to call this *before* you use the addr variable
@@ -53,7 +132,37 @@ func main() {
 	// encountered during parsing the application will be terminated.
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil)) // initialize a new structured logger
+	// initialize a new structured logger
+	logger, err := newLogger(os.Stdout, *logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	origins, err := parseOrigins(*embedOrigins)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(2)
+	}
+
+	mail, err := newMailer(*mailerType, *mailSender, *outboxDir, *smtpHost, *smtpPort, *smtpUsername, *smtpPassword)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(2)
+	}
+
+	key := []byte(*secretKey)
+	if len(key) == 0 {
+		logger.Warn("no secret key set; links in emails will stop working when the server restarts")
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	tracerProvider, closeTraceFile, err := newTracerProvider(*traceExporter, *traceFile)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
 	// Pass openDB() the DSN from the command-line flag.
 	db, err := openDB(*dsn)
@@ -69,24 +178,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	metrics := newMetrics()
+	// Export the connection pool statistics from sql.DB.Stats().
+	metrics.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+
+	// Wrap the snippet model in the caching decorator if it was enabled. Both
+	// satisfy models.SnippetModelInterface, so the handlers don't notice.
+	// Starring a snippet changes its cached star count, so the star model has
+	// to be wrapped as well.
+	var snippets models.SnippetModelInterface = &models.SnippetModel{DB: db}
+	var stars models.StarModelInterface = &models.StarModel{DB: db}
+	if *cacheTTL > 0 {
+		cache := models.NewCachedSnippetModel(snippets, *cacheTTL)
+		metrics.registerCache(cache)
+		snippets = cache
+		stars = cache.StarModel(stars)
+	}
+
 	formDecoder := form.NewDecoder()
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db) // uses mysql to manage sessions
-	sessionManager.Lifetime = 12 * time.Hour  // lifetime of 12 hours for each session
+	// uses mysql to manage sessions, counting every store operation
+	sessionStore := mysqlstore.New(db)
+	sessionManager.Store = &instrumentedStore{Store: sessionStore, ops: metrics.sessionOps}
+	sessionManager.Lifetime = 12 * time.Hour // lifetime of 12 hours for each session
 	// Make sure that the Secure attribute is set on our session cookies.
 	// Setting this means that the cookie will only be sent by a user's web
 	// browser when a HTTPS connection is being used (and won't be sent over an
 	// unsecure HTTP connection).
 	sessionManager.Cookie.Secure = true
 
+	webhooks := &models.WebhookModel{DB: db}
+	views := &models.ViewModel{DB: db}
+
 	// initialize a new instance of applicaiton struct containing dependencies
 	app := &application{
-		logger:         logger,
-		snippets:       &models.SnippetModel{DB: db}, // Initialize a models.SnippetModel instance containing the connection pool
-		users:          &models.UserModel{DB: db},    // Initialize a models.UserModel instance.
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		logger:            logger,
+		snippets:          snippets,
+		users:             &models.UserModel{DB: db}, // Initialize a models.UserModel instance.
+		passwordResets:    &models.PasswordResetModel{DB: db},
+		twoFactor:         &models.TwoFactorModel{DB: db},
+		tokens:            &models.TokenModel{DB: db},
+		webhooks:          webhooks,
+		stars:             stars,
+		comments:          &models.CommentModel{DB: db},
+		collections:       &models.CollectionModel{DB: db},
+		views:             views,
+		templateCache:     templateCache,
+		formDecoder:       formDecoder,
+		sessionManager:    sessionManager,
+		metrics:           metrics,
+		logLevel:          logLevel,
+		adminToken:        *adminToken,
+		mailer:            mail,
+		signer:            signer{key: key},
+		baseURL:           strings.TrimSuffix(*baseURL, "/"),
+		pasteLimiters:     newPasteLimiters(),
+		twoFactorLimiter:  newTwoFactorLimiter(),
+		embedOrigins:      origins,
+		webhookDispatcher: newWebhookDispatcher(webhooks, logger, *webhookAllowPrivate),
+		viewCounter:       newViewCounter(views, logger),
+		readinessChecks: map[string]readinessCheck{
+			"database":      databaseCheck(db),
+			"session_store": sessionStoreCheck(sessionStore),
+		},
 	}
 
 	// Initialize a tls.Config struct to hold the non-default TLS settings we
@@ -110,13 +264,114 @@ func main() {
 		TLSConfig: tlsConfig, // Set the server's TLSConfig field
 	}
 
+	// Start the admin listener in the background. It uses plain HTTP, as it is
+	// only meant to be reached by local tooling such as a Prometheus scraper.
+	var adminSrv *http.Server
+	if *adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         *adminAddr,
+			Handler:      app.adminRoutes(),
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.Info("starting admin server", slog.String("addr", *adminAddr))
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err.Error())
+			}
+		}()
+	}
+
+	// Start delivering webhooks. The dispatcher is stopped during shutdown,
+	// and any deliveries it hasn't made are left in the queue for next time.
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	go func() {
+		app.webhookDispatcher.run(dispatcherCtx)
+		close(dispatcherDone)
+	}()
+
+	// Start flushing view counts. Like the dispatcher, it is stopped once
+	// the handlers have finished, and flushes the counts it has left.
+	viewCounterCtx, stopViewCounter := context.WithCancel(context.Background())
+	viewCounterDone := make(chan struct{})
+	go func() {
+		app.viewCounter.run(viewCounterCtx)
+		close(viewCounterDone)
+	}()
+
+	// The shutdownError channel receives any error returned by the graceful
+	// Shutdown() function.
+	shutdownError := make(chan error)
+
+	go func() {
+		// Wait for a SIGINT or SIGTERM signal.
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		logger.Info("shutting down server", slog.String("signal", sig.String()))
+
+		// Start failing readiness checks, and give load balancers some time to
+		// stop routing new requests to us before we stop accepting them.
+		app.shuttingDown.Store(true)
+		time.Sleep(*drainDelay)
+
+		// Give in-flight requests up to 30 seconds to complete.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+
+		err := srv.Shutdown(ctx)
+
+		// Wait for any emails which are still being sent.
+		app.backgroundTasks.Wait()
+
+		// Stop the webhook dispatcher once the handlers which queue events
+		// have finished, and wait for it to record any attempts in progress.
+		stopDispatcher()
+		<-dispatcherDone
+
+		// Write the views counted since the last flush.
+		stopViewCounter()
+		<-viewCounterDone
+
+		// Flush any spans which haven't been exported yet, and then close the
+		// file they were written to, if any.
+		if tracerProvider != nil {
+			tracerProvider.Shutdown(ctx)
+		}
+		closeTraceFile()
+
+		shutdownError <- err
+	}()
+
 	// Use the Info() method to log the starting server message at Info severity
 	// (along with the listen address as an attribute).
 	logger.Info("starting server", slog.String("addr", *addr))
 
+	// Calling Shutdown() makes ListenAndServeTLS() return http.ErrServerClosed
+	// straight away, so anything else is a genuine error.
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	logger.Error(err.Error())
-	os.Exit(1)
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Wait for the in-flight requests to complete.
+	err = <-shutdownError
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("stopped server", slog.String("addr", *addr))
 }
 
 // The openDB() function wraps sql.Open() and returns a sql.DB connection pool
@@ -135,3 +390,21 @@ func openDB(dsn string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// newMailer returns the mailer of the given type.
+func newMailer(kind, sender, outboxDir, smtpHost string, smtpPort int, smtpUsername, smtpPassword string) (mailer.Mailer, error) {
+	switch kind {
+	case "file":
+		return &mailer.FileOutbox{Dir: outboxDir, Sender: sender}, nil
+	case "smtp":
+		return &mailer.SMTP{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: smtpUsername,
+			Password: smtpPassword,
+			Sender:   sender,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown converts Markdown to HTML. Raw HTML in the source is left out
+// (goldmark only includes it with the html.WithUnsafe() option), headings are
+// given IDs, and fenced code blocks get the same language-* class as the code
+// in other snippets.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(headingAnchors{}, 100)),
+	),
+)
+
+// markdownPolicy sanitizes the HTML converted from Markdown, as a second line
+// of defence against anything which slips through. It is based on the
+// policy for user generated content, which doesn't allow scripts, styles or
+// event handler attributes, so the output also keeps to our Content Security
+// Policy.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Only links to other sites need rel="nofollow", not the heading anchors.
+	p.RequireNoFollowOnLinks(false)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^anchor$`)).OnElements("a")
+	return p
+}()
+
+// renderMarkdown returns the sanitized HTML for a Markdown document. The IDs
+// given to headings start with "md-" and idPrefix, so that they don't clash
+// with the IDs in the rest of the page (such as #comments) or with the
+// headings of other Markdown files on it.
+func renderMarkdown(idPrefix, source string) template.HTML {
+	var buf bytes.Buffer
+
+	ctx := parser.NewContext(parser.WithIDs(prefixedIDs{
+		IDs:    parser.NewContext().IDs(),
+		prefix: []byte("md-" + idPrefix + "-"),
+	}))
+
+	err := markdown.Convert([]byte(source), &buf, parser.WithContext(ctx))
+	if err != nil {
+		// Converting to a bytes.Buffer can't fail, but just in case show
+		// the source rather than nothing.
+		return template.HTML("<pre>" + template.HTMLEscapeString(source) + "</pre>")
+	}
+
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes()))
+}
+
+// prefixedIDs generates heading IDs in the same way as goldmark's default
+// parser.IDs, but with a prefix.
+type prefixedIDs struct {
+	parser.IDs
+	prefix []byte
+}
+
+func (ids prefixedIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	return append(append([]byte(nil), ids.prefix...), ids.IDs.Generate(value, kind)...)
+}
+
+// commentMarkdown converts comments, which only support a small part of
+// Markdown: paragraphs, emphasis, code, links, lists and quotes. Line breaks
+// are kept as they were typed, since comments tend to be short.
+var commentMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// commentPolicy only allows the elements which comments support. Anything
+// else, such as headings, images and tables, is reduced to its text.
+var commentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowStandardURLs()
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	return p
+}()
+
+// renderComment returns the sanitized HTML for the body of a comment.
+func renderComment(source string) template.HTML {
+	var buf bytes.Buffer
+
+	err := commentMarkdown.Convert([]byte(source), &buf)
+	if err != nil {
+		return template.HTML("<p>" + template.HTMLEscapeString(source) + "</p>")
+	}
+
+	return template.HTML(commentPolicy.SanitizeBytes(buf.Bytes()))
+}
+
+// headingAnchors is a goldmark AST transformer which adds a "#" link to each
+// heading, pointing at the heading itself, so that readers can link to a
+// section of a runbook.
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), id.([]byte)...)
+		link.SetAttributeString("class", []byte("anchor"))
+		link.AppendChild(link, ast.NewString([]byte("#")))
+
+		heading.AppendChild(heading, ast.NewString([]byte(" ")))
+		heading.AppendChild(heading, link)
+
+		return ast.WalkSkipChildren, nil
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		wantNone []string
+	}{
+		{
+			name:   "Heading anchor",
+			source: "# Restart the service",
+			want:   `<h1 id="md-0-restart-the-service">Restart the service <a href="#md-0-restart-the-service" class="anchor">#</a></h1>`,
+		},
+		{
+			name:   "Fenced code block",
+			source: "```go\nfmt.Println(\"<hi>\")\n```",
+			want:   `<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)`,
+		},
+		{
+			name:     "Raw HTML",
+			source:   "Run <script>alert(1)</script> now\n\n<div onclick=\"steal()\">hello</div>",
+			want:     "<p>Run alert(1) now</p>",
+			wantNone: []string{"<script", "onclick", "<div"},
+		},
+		{
+			name:     "JavaScript link",
+			source:   "[click me](javascript:alert(1))",
+			want:     "<p>click me</p>",
+			wantNone: []string{"javascript:"},
+		},
+		{
+			name:   "External link",
+			source: "[Go](https://go.dev)",
+			want:   `<a href="https://go.dev" rel="nofollow">Go</a>`,
+		},
+		{
+			name:     "Styles",
+			source:   "| a | b |\n|:-|-:|\n| 1 | 2 |",
+			want:     "<th>a</th>",
+			wantNone: []string{"style=", "align="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := string(renderMarkdown("0", tt.source))
+
+			assert.StringContains(t, html, tt.want)
+			for _, none := range tt.wantNone {
+				assert.Equal(t, strings.Contains(html, none), false)
+			}
+		})
+	}
+}
+
+// Headings in Markdown files mustn't be given the same IDs as elements in
+// the rest of the page, or as the headings in other files.
+func TestRenderMarkdownHeadingIDs(t *testing.T) {
+	source := "# Comments\n\n# Comment 5\n\n# Comments"
+
+	first := string(renderMarkdown("0", source))
+	second := string(renderMarkdown("1", source))
+
+	assert.StringContains(t, first, `<h1 id="md-0-comments">`)
+	assert.StringContains(t, first, `<h1 id="md-0-comment-5">`)
+	assert.StringContains(t, first, `<h1 id="md-0-comments-1">`)
+	assert.StringContains(t, second, `<h1 id="md-1-comments">`)
+
+	for _, id := range []string{`id="comments"`, `id="comment-5"`} {
+		assert.Equal(t, strings.Contains(first+second, id), false)
+	}
+}
+
+func TestRenderComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		wantNone []string
+	}{
+		{
+			name:   "Emphasis and code",
+			source: "This is **really** `slow`",
+			want:   "<p>This is <strong>really</strong> <code>slow</code></p>",
+		},
+		{
+			name:   "Line breaks",
+			source: "first\nsecond",
+			want:   "<p>first<br>\nsecond</p>",
+		},
+		{
+			name:   "Bare link",
+			source: "See https://go.dev",
+			want:   `<a href="https://go.dev" rel="nofollow">https://go.dev</a>`,
+		},
+		{
+			name:     "Heading",
+			source:   "# Big",
+			want:     "Big",
+			wantNone: []string{"<h1"},
+		},
+		{
+			name:     "Image",
+			source:   "![x](https://example.com/x.png)",
+			wantNone: []string{"<img"},
+		},
+		{
+			name:     "Raw HTML",
+			source:   "<script>alert(1)</script>",
+			wantNone: []string{"<script"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := string(renderComment(tt.source))
+
+			assert.StringContains(t, html, tt.want)
+			for _, none := range tt.wantNone {
+				assert.Equal(t, strings.Contains(html, none), false)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The metrics struct holds the Prometheus collectors for the application. Each
+// application gets its own registry (rather than using the global default one)
+// so that tests can create as many applications as they like.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	renderDuration  *prometheus.HistogramVec
+	sessionOps      *prometheus.CounterVec
+	signups         prometheus.Counter
+	logins          *prometheus.CounterVec
+	snippetsCreated prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "Number of HTTP requests by route pattern and status code.",
+		}, []string{"route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "HTTP request latency by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "snippetbox_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served.",
+		}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "Time taken to execute a page template.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"page"}),
+		sessionOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_session_store_operations_total",
+			Help: "Number of session store operations by operation and result.",
+		}, []string{"op", "result"}),
+		signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_signups_total",
+			Help: "Number of successful user signups.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_logins_total",
+			Help: "Number of login attempts by result.",
+		}, []string{"result"}),
+		snippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Number of snippets created.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.inFlight,
+		m.renderDuration,
+		m.sessionOps,
+		m.signups,
+		m.logins,
+		m.snippetsCreated,
+	)
+
+	return m
+}
+
+// registerCache exports the hit and miss counters of the snippet cache.
+func (m *metrics) registerCache(cache *models.CachedSnippetModel) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "snippetbox_snippet_cache_hits_total",
+			Help: "Number of snippet queries served from the in-memory cache.",
+		}, func() float64 { return float64(cache.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "snippetbox_snippet_cache_misses_total",
+			Help: "Number of snippet queries which missed the in-memory cache.",
+		}, func() float64 { return float64(cache.Stats().Misses) }),
+	)
+}
+
+// handler returns a http.Handler which serves the metrics in the Prometheus
+// text exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument returns a middleware which records the request count, latency
+// and in-flight gauge. It needs the servemux so that requests can be labelled
+// with the route pattern they match (e.g. "GET /snippet/view/{id}") rather
+// than the raw URL path, which would give us one time series per snippet.
+func (m *metrics) instrument(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+
+			start := time.Now()
+			rw := newResponseRecorder(w)
+
+			next.ServeHTTP(rw, r)
+
+			code := strconv.Itoa(rw.status)
+			m.requests.WithLabelValues(route, code).Inc()
+			m.requestDuration.WithLabelValues(route, code).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// instrumentedStore wraps a scs.Store and counts the operations made on it.
+type instrumentedStore struct {
+	scs.Store
+	ops *prometheus.CounterVec
+}
+
+func (s *instrumentedStore) observe(op string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	s.ops.WithLabelValues(op, result).Inc()
+}
+
+func (s *instrumentedStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.Store.Find(token)
+	s.observe("find", err)
+	return b, found, err
+}
+
+func (s *instrumentedStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := s.Store.Commit(token, b, expiry)
+	s.observe("commit", err)
+	return err
+}
+
+func (s *instrumentedStore) Delete(token string) error {
+	err := s.Store.Delete(token)
+	s.observe("delete", err)
+	return err
+}
+
+// The instrumentedStore also implements scs.CtxStore, so that the session
+// manager passes us the request context and we can add a span for each store
+// operation to the request's trace.
+
+func (s *instrumentedStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, span := tracer.Start(ctx, "session.load")
+	defer span.End()
+
+	var (
+		b     []byte
+		found bool
+		err   error
+	)
+	if cs, ok := s.Store.(scs.CtxStore); ok {
+		b, found, err = cs.FindCtx(ctx, token)
+	} else {
+		b, found, err = s.Store.Find(token)
+	}
+	s.observe("find", err)
+	recordSpanError(span, err)
+
+	return b, found, err
+}
+
+func (s *instrumentedStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ctx, span := tracer.Start(ctx, "session.save")
+	defer span.End()
+
+	var err error
+	if cs, ok := s.Store.(scs.CtxStore); ok {
+		err = cs.CommitCtx(ctx, token, b, expiry)
+	} else {
+		err = s.Store.Commit(token, b, expiry)
+	}
+	s.observe("commit", err)
+	recordSpanError(span, err)
+
+	return err
+}
+
+func (s *instrumentedStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, span := tracer.Start(ctx, "session.delete")
+	defer span.End()
+
+	var err error
+	if cs, ok := s.Store.(scs.CtxStore); ok {
+		err = cs.DeleteCtx(ctx, token)
+	} else {
+		err = s.Store.Delete(token)
+	}
+	s.observe("delete", err)
+	recordSpanError(span, err)
+
+	return err
+}
+
+// The instrumentedStore also forwards scs.IterableStore and
+// scs.IterableCtxStore, so that the session manager can still iterate over
+// the sessions in stores which support it, such as mysqlstore.
+
+func (s *instrumentedStore) All() (map[string][]byte, error) {
+	return s.AllCtx(context.Background())
+}
+
+func (s *instrumentedStore) AllCtx(ctx context.Context) (map[string][]byte, error) {
+	ctx, span := tracer.Start(ctx, "session.all")
+	defer span.End()
+
+	var (
+		sessions map[string][]byte
+		err      error
+	)
+	switch store := s.Store.(type) {
+	case scs.IterableCtxStore:
+		sessions, err = store.AllCtx(ctx)
+	case scs.IterableStore:
+		sessions, err = store.All()
+	default:
+		err = fmt.Errorf("session store %T does not support iteration", s.Store)
+	}
+	s.observe("all", err)
+	recordSpanError(span, err)
+
+	return sessions, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+// The instrumentedStore keeps the optional interfaces of the store it wraps,
+// so that the session manager can still use them.
+func TestInstrumentedStore(t *testing.T) {
+	ctx := context.Background()
+	m := newMetrics()
+
+	var store scs.Store = &instrumentedStore{Store: memstore.New(), ops: m.sessionOps}
+
+	cs, ok := store.(scs.CtxStore)
+	assert.Equal(t, ok, true)
+	is, ok := store.(scs.IterableCtxStore)
+	assert.Equal(t, ok, true)
+
+	err := cs.CommitCtx(ctx, "token", []byte("data"), time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	sessions, err := is.AllCtx(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, string(sessions["token"]), "data")
+
+	assert.Equal(t, counterValue(t, m.sessionOps.WithLabelValues("commit", "success")), 1.0)
+	assert.Equal(t, counterValue(t, m.sessionOps.WithLabelValues("all", "success")), 1.0)
+
+	// Stores which can't be iterated over return an error rather than
+	// pretending to have no sessions.
+	_, err = (&instrumentedStore{Store: struct{ scs.Store }{memstore.New()}, ops: m.sessionOps}).All()
+	assert.Equal(t, err != nil, true)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var metric dto.Metric
+
+	err := c.Write(&metric)
+	assert.NilError(t, err)
+
+	return metric.GetCounter().GetValue()
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel/trace"
+	"snippetbox.dkimhw.com/internal/models"
 )
 
+// The Content Security Policy for our pages, apart from the frame-ancestors
+// directive which is added by commonHeaders and allowFraming.
+const contentSecurityPolicy = "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+
 func commonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// restrict where the resources for your web page (e.g. JavaScript, images, fonts etc) can be loaded from
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		// restrict where the resources for your web page (e.g. JavaScript, images, fonts etc) can be loaded from,
+		// and stop other sites from framing them
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy+"; frame-ancestors 'none'")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		// prevent content-sniffing attacks
 		w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -25,6 +35,35 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// The requestID middleware makes sure every request has an ID. An ID sent by
+// the client (or a proxy in front of us) in the X-Request-ID header is reused
+// so that log entries can be correlated across services; otherwise a new one
+// is generated. The ID is echoed back in the response header, and stored in
+// the request context along with a logger which includes it in every entry.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		logger := app.logger.With("request_id", id)
+		// If the request is being traced, include the trace ID too so that log
+		// entries can be matched up with the trace.
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		ctx = context.WithValue(ctx, loggerContextKey, logger)
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -32,11 +71,17 @@ func (app *application) logRequest(next http.Handler) http.Handler {
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
+			logger = app.requestLogger(r)
 		)
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
 
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rw := newResponseRecorder(w)
+
+		next.ServeHTTP(rw, r)
+
+		logger.Info("completed request", "method", method, "uri", uri, "status", rw.status, "bytes", rw.bytes, "duration", time.Since(start))
 	})
 }
 
@@ -81,6 +126,146 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// The authenticateToken middleware authenticates API requests which carry a
+// personal access token in an "Authorization: Bearer" header. A valid token
+// marks the request as authenticated in exactly the same way as a session
+// does in authenticate(), so requireAPIAuthentication works unchanged.
+// Requests without the header carry on anonymously.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tell any caches that the response may vary depending on the
+		// Authorization header.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		plaintext, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+		if !ok {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		token, err := app.tokens.Authenticate(r.Context(), plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.apiInvalidToken(w, r)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, token.UserID)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The requireScope middleware checks that the request's token, if it has one,
+// grants the given scope. Anonymous requests are let through; combine it with
+// requireAPIAuthentication for routes which need a token.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenContextKey).(models.Token)
+			if ok && !token.Allows(scope) {
+				app.apiErrorResponse(w, r, http.StatusForbidden, apiError{
+					Message: fmt.Sprintf("your token does not have the %q scope required for this resource", scope),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The requireAPIAuthentication middleware is the API equivalent of
+// requireAuthentication: instead of redirecting to the login page it sends a
+// 401 Unauthorized JSON error.
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.apiErrorResponse(w, r, http.StatusUnauthorized, apiError{Message: "you must be authenticated to access this resource"})
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAdminToken middleware protects admin endpoints. The request must
+// carry the configured token in an "Authorization: Bearer" header; if no token
+// has been configured, access is always refused.
+func (app *application) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		// Use a constant time comparison to avoid leaking the token through
+		// timing differences.
+		if app.adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The allowFraming middleware lets the route be displayed in a frame by our
+// own pages and by the origins in app.embedOrigins, overriding the
+// anti-framing headers set by commonHeaders. X-Frame-Options can't express an
+// allowlist, so it is removed and browsers rely on frame-ancestors instead.
+func (app *application) allowFraming(next http.Handler) http.Handler {
+	frameAncestors := strings.Join(append([]string{"'self'"}, app.embedOrigins...), " ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy+"; frame-ancestors "+frameAncestors)
+		w.Header().Del("X-Frame-Options")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The limitRequestBody middleware refuses requests with bodies larger than n
+// bytes. Requests which say how long their body is up front are refused
+// straight away. Otherwise reading more than n bytes fails with an
+// *http.MaxBytesError, which noSurf and the handler have to turn into a 413
+// response themselves (see bodyTooLarge).
+func (app *application) limitRequestBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				app.clientError(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyTooLarge reports whether reading the request body has failed because it
+// was longer than the limit set by limitRequestBody. Once the limit has been
+// reached, every read of the body returns the same *http.MaxBytesError.
+func bodyTooLarge(r *http.Request) bool {
+	_, err := r.Body.Read(make([]byte, 1))
+
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with
 // the Secure, Path, and HttpOnly attributes set.
 func noSurf(next http.Handler) http.Handler {
@@ -91,9 +276,47 @@ func noSurf(next http.Handler) http.Handler {
 		Secure:   true,
 	})
 
+	// noSurf parses the form to look for the CSRF token, so if the body is
+	// too large it doesn't find one. Report that rather than a CSRF failure.
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := nosurf.FailureCode
+		if bodyTooLarge(r) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
+	}))
+
 	return csrfHandler
 }
 
+// requireVerifiedEmail stops users who haven't verified their email address
+// from using the routes which publish things or give access to the API. It
+// must come after requireAuthentication in the chain.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			// The user has been deleted since authenticate() checked for
+			// them, so log them out.
+			if errors.Is(err, models.ErrNoRecord) {
+				app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if !user.EmailVerified {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address first. You can ask for another verification email below.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the authenticatedUserID value from the session using the GetInt() method.
@@ -103,19 +326,59 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, r, err)
 			return
 		}
 
 		// Matching user found - request is coming from authenticated user
-		// Create a copy of the request and assign it to the request
-		if exists {
+		// Create a copy of the request and assign it to the request. Sessions
+		// from before the user's session version was last changed (e.g. by a
+		// password reset) are no longer valid.
+		if err == nil && user.SessionVersion == app.sessionManager.GetInt(r.Context(), "sessionVersion") {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The responseRecorder type wraps a http.ResponseWriter and records the status
+// code and number of bytes written, so that middleware can report on them
+// after the handler has returned.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	// If a handler never calls WriteHeader() Go sends a 200 OK, so that's
+	// the default.
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, which lets
+// http.ResponseController reach methods like Flush() that we don't wrap.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"snippetbox.dkimhw.com/internal/assert"
@@ -37,7 +40,7 @@ func TestCommonHeaders(t *testing.T) {
 
 	// Check that the middleware has correctly set the Content-Security-Policy header
 	// on the response.
-	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; frame-ancestors 'none'"
 	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
 
 	// Check that the middleware has correctly set the Referrer-Policy
@@ -78,3 +81,91 @@ func TestCommonHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+// A user who is deleted while they are logged in is logged out, rather than
+// getting a server error.
+func TestRequireVerifiedEmailDeletedUser(t *testing.T) {
+	app := newTestApplication(t)
+
+	ctx, err := app.sessionManager.Load(context.Background(), "")
+	assert.NilError(t, err)
+	app.sessionManager.Put(ctx, "authenticatedUserID", 99)
+	ctx = context.WithValue(ctx, isAuthenticatedContextKey, true)
+	ctx = context.WithValue(ctx, authenticatedUserIDContextKey, 99)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/snippet/create", nil)
+	assert.NilError(t, err)
+	rr := httptest.NewRecorder()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler called")
+	})
+	app.requireVerifiedEmail(next).ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Code, http.StatusSeeOther)
+	assert.Equal(t, rr.Header().Get("Location"), "/user/login")
+	assert.Equal(t, app.sessionManager.GetInt(ctx, "authenticatedUserID"), 0)
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name      string
+		requestID string
+		wantSame  bool
+	}{
+		{
+			name:      "Client-supplied ID",
+			requestID: "abc-123",
+			wantSame:  true,
+		},
+		{
+			name:      "No ID",
+			requestID: "",
+		},
+		{
+			name:      "Invalid ID",
+			requestID: "<script>",
+		},
+		{
+			name:      "Too long ID",
+			requestID: strings.Repeat("a", 129),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.requestID != "" {
+				r.Header.Set("X-Request-ID", tt.requestID)
+			}
+
+			// Record the request ID which the next handler sees in the
+			// request context, and then make it fail with a server error.
+			var contextID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID = requestID(r)
+				app.serverError(w, r, errors.New("something went wrong"))
+			})
+
+			app.requestID(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			headerID := rs.Header.Get("X-Request-ID")
+
+			assert.Equal(t, headerID, contextID)
+			assert.Equal(t, validRequestID(headerID), true)
+			assert.Equal(t, headerID == tt.requestID, tt.wantSame)
+
+			// The 500 page should show the ID to the user.
+			assert.Equal(t, rs.StatusCode, http.StatusInternalServerError)
+			assert.StringContains(t, rr.Body.String(), "Request ID: "+headerID)
+		})
+	}
+}
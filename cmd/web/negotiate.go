@@ -0,0 +1,92 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The media types which pages can be negotiated into.
+const (
+	mediaTypeHTML = "text/html"
+	mediaTypeJSON = "application/json"
+	mediaTypeText = "text/plain"
+	mediaTypeZip  = "application/zip"
+)
+
+// formatSuffixes maps the URL suffixes which override the Accept header to the
+// media types they select.
+var formatSuffixes = map[string]string{
+	".json": mediaTypeJSON,
+	".txt":  mediaTypeText,
+	".zip":  mediaTypeZip,
+}
+
+// negotiate returns the offered media type which best matches the request's
+// Accept header. Offers are given in order of the server's preference, which
+// breaks ties (so a client sending "*/*" gets the first one). If the client
+// doesn't accept any of them we're lenient and return the first offer too,
+// rather than sending a 406 Not Acceptable.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := offers[0], 0.0
+
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// acceptQuality returns the quality value which the Accept header gives to the
+// media type, using the most specific media range which matches it. For
+// example, with "text/*;q=0.5, text/plain" the quality of text/plain is 1 and
+// that of text/html is 0.5.
+func acceptQuality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		var s int
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s <= specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		if v, ok := params["q"]; ok {
+			rangeQ, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		q, specificity = rangeQ, s
+	}
+
+	return q
+}
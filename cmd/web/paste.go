@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+// The defaults for pastes which don't specify a title or expiry.
+const (
+	defaultPasteTitle   = "Untitled paste"
+	defaultPasteExpires = 365
+)
+
+// The pastePost handler creates a snippet from the raw request body, so that
+// sharing the output of a command is as simple as:
+//
+//	some-cmd | curl --data-binary @- https://localhost:4000/paste
+//
+// The title, expiry and comma-separated tags can be given in the "title",
+// "expires" and "tags" query string parameters, or the X-Title, X-Expires and
+// X-Tags headers. As with files in the form and the API, the content can be
+// at most maxFileSize bytes. The response is the URL of the new snippet as
+// plain text.
+func (app *application) pastePost(w http.ResponseWriter, r *http.Request) {
+	// Errors are sent as plain text, which is easier to read in a terminal
+	// than HTML or JSON. Use the same 1MB limit on the body as the JSON API.
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, fmt.Sprintf("Body must not be larger than %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !utf8.Valid(body) {
+		http.Error(w, "Body must be UTF-8 text", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var v validator.Validator
+
+	title := pasteParam(r, "title", "X-Title")
+	if title == "" {
+		title = defaultPasteTitle
+	}
+
+	expires := defaultPasteExpires
+	if s := pasteParam(r, "expires", "X-Expires"); s != "" {
+		expires, err = strconv.Atoi(s)
+		if err != nil {
+			v.AddFieldError("expires", "This field must be an integer")
+		}
+	}
+
+	tags := parseTags(pasteParam(r, "tags", "X-Tags"))
+
+	validateSnippet(&v, title, expires)
+	validateContent(&v, string(body))
+	validateTags(&v, tags)
+	if !v.Valid() {
+		http.Error(w, pasteValidationMessage(v), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Pastes made without a token don't belong to anybody.
+	userID := app.authenticatedUserID(r)
+
+	files := normalizeFiles([]models.File{{Content: string(body)}})
+
+	id, err := app.snippets.Insert(r.Context(), userID, title, files, expires, tags)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+	if userID != 0 {
+		app.notifySnippetCreated(r, id)
+	}
+
+	url := fmt.Sprintf("%s/snippet/view/%d", app.baseURL, id)
+
+	w.Header().Set("Location", url)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
+
+// pasteParam returns the value of the query string parameter with the given
+// name, falling back to the header.
+func pasteParam(r *http.Request, name, header string) string {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		value = r.Header.Get(header)
+	}
+	return strings.TrimSpace(value)
+}
+
+// pasteValidationMessage formats the validation errors as one "field: message"
+// line per field, in a stable order.
+func pasteValidationMessage(v validator.Validator) string {
+	fields := make([]string, 0, len(v.FieldErrors))
+	for field := range v.FieldErrors {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	lines := slices.Clone(v.NoneFieldErrors)
+	for _, field := range fields {
+		lines = append(lines, field+": "+v.FieldErrors[field])
+	}
+
+	return strings.Join(lines, "\n")
+}
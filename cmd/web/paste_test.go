@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+func TestPastePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	withToken := map[string]string{"Authorization": "Bearer " + mocks.WriteToken}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		headers  map[string]string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Anonymous",
+			urlPath:  "/paste",
+			body:     "hello world\n",
+			wantCode: http.StatusCreated,
+			wantBody: app.baseURL + "/snippet/view/1",
+		},
+		{
+			name:     "With token",
+			urlPath:  "/paste",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusCreated,
+			wantBody: app.baseURL + "/snippet/view/1",
+		},
+		{
+			name:     "Title and expires in query string",
+			urlPath:  "/paste?title=Build+log&expires=7",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Title and expires in headers",
+			urlPath:  "/paste",
+			headers:  map[string]string{"Authorization": "Bearer " + mocks.WriteToken, "X-Title": "Build log", "X-Expires": "1"},
+			body:     "hello world\n",
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Empty body",
+			urlPath:  "/paste",
+			headers:  withToken,
+			body:     "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "content: This field cannot be blank",
+		},
+		{
+			name:     "Invalid expires",
+			urlPath:  "/paste?expires=30",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "expires: This field must equal 1, 7 or 365",
+		},
+		{
+			name:     "Non-integer expires",
+			urlPath:  "/paste?expires=week",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "expires: This field must be an integer",
+		},
+		{
+			name:     "Long title",
+			urlPath:  "/paste?title=" + strings.Repeat("a", 101),
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "title: This field cannot be more than 100 characters long",
+		},
+		{
+			name:     "Tags",
+			urlPath:  "/paste?tags=Go,+shell,go",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Too many tags",
+			urlPath:  "/paste?tags=a,b,c,d,e,f",
+			headers:  withToken,
+			body:     "hello world\n",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "tags: This field cannot have more than 5 tags",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/paste",
+			headers:  map[string]string{"Authorization": "Bearer " + mocks.WriteToken, "X-Tags": "c++"},
+			body:     "hello world\n",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "tags: Tags can only contain letters, numbers and hyphens",
+		},
+		{
+			name:     "Binary body",
+			urlPath:  "/paste",
+			headers:  withToken,
+			body:     "\xff\xfe\x00",
+			wantCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:     "Content too large",
+			urlPath:  "/paste",
+			headers:  withToken,
+			body:     strings.Repeat("a", maxFileSize+1),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "content: This field cannot be more than 64KB",
+		},
+		{
+			name:     "Too large",
+			urlPath:  "/paste",
+			headers:  withToken,
+			body:     strings.Repeat("a", 1_048_577),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "Read-only token",
+			urlPath:  "/paste",
+			headers:  map[string]string{"Authorization": "Bearer " + mocks.ReadToken},
+			body:     "hello world\n",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Invalid token",
+			urlPath:  "/paste",
+			headers:  map[string]string{"Authorization": "Bearer sbx_WRONG"},
+			body:     "hello world\n",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.do(t, http.MethodPost, tt.urlPath, tt.headers, tt.body)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+
+			if code == http.StatusCreated {
+				assert.Equal(t, headers.Get("Location"), app.baseURL+"/snippet/view/1")
+			}
+		})
+	}
+}
+
+func TestPasteRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Use up the anonymous burst allowance.
+	for i := 0; i < app.pasteLimiters.anonymous.burst; i++ {
+		code, _, _ := ts.do(t, http.MethodPost, "/paste", nil, "hello world\n")
+		assert.Equal(t, code, http.StatusCreated)
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		code, headers, _ := ts.do(t, http.MethodPost, "/paste", nil, "hello world\n")
+
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, headers.Get("Retry-After") != "", true)
+	})
+
+	t.Run("With token", func(t *testing.T) {
+		headers := map[string]string{"Authorization": "Bearer " + mocks.WriteToken}
+		code, _, _ := ts.do(t, http.MethodPost, "/paste", headers, "hello world\n")
+
+		assert.Equal(t, code, http.StatusCreated)
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Clients which haven't been seen for this long are forgotten by a
+// rateLimiter, which keeps its map from growing without bound.
+const rateLimiterIdleTimeout = 3 * time.Minute
+
+// A rateLimiter keeps a token bucket for each client, identified by an
+// arbitrary string key.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*rateLimiterClient
+	lastSweep time.Time
+}
+
+type rateLimiterClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns a rateLimiter which allows each client up to burst
+// requests at once, refilled at limit requests per second.
+func newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		burst:     burst,
+		clients:   make(map[string]*rateLimiterClient),
+		lastSweep: time.Now(),
+	}
+}
+
+// reserve takes a token for the client with the given key. If there isn't one
+// available it returns false, along with how long the client should wait
+// before trying again.
+func (rl *rateLimiter) reserve(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+
+	// Remove idle clients every so often. Doing it here rather than in a
+	// background goroutine means there's nothing to shut down.
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTimeout {
+		for k, c := range rl.clients {
+			if now.Sub(c.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &rateLimiterClient{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = now
+
+	reservation := c.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// We're rejecting the request, so give the token back.
+		reservation.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// The pasteLimiters struct holds the rate limiters for the paste endpoint.
+// Anonymous pastes get much stricter limits than ones made with an API token,
+// since they're the ones most likely to be abused.
+type pasteLimiters struct {
+	anonymous     *rateLimiter
+	authenticated *rateLimiter
+}
+
+func newPasteLimiters() pasteLimiters {
+	return pasteLimiters{
+		// 5 pastes at once, then one every 12 seconds.
+		anonymous: newRateLimiter(rate.Every(12*time.Second), 5),
+		// 20 pastes at once, then one a second.
+		authenticated: newRateLimiter(rate.Limit(1), 20),
+	}
+}
+
+// The limitPaste middleware rate limits the paste endpoint. Authenticated
+// requests are limited per user (rather than per token, so that creating more
+// tokens doesn't help) and anonymous requests per IP address. It must come
+// after authenticateToken in the chain.
+func (app *application) limitPaste(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := app.pasteLimiters.anonymous
+		key := clientIP(r)
+
+		if app.isAuthenticated(r) {
+			limiter = app.pasteLimiters.authenticated
+			key = "user:" + strconv.Itoa(app.authenticatedUserID(r))
+		}
+
+		ok, retryAfter := limiter.reserve(key)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Rate limit exceeded, please try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client which made the request. We
+// don't trust X-Forwarded-For, as any client could set it.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/mailer"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+const (
+	// How long a password reset link can be used for.
+	passwordResetTTL = 30 * time.Minute
+	// How long a user has to wait before another reset email can be sent to
+	// them.
+	passwordResetInterval = 2 * time.Minute
+)
+
+type passwordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// passwordResetForm is validated in the same way as accountPasswordUpdateForm,
+// but has the reset token instead of the current password.
+type passwordResetForm struct {
+	Token                   string `form:"token"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordForgotForm{}
+	app.render(w, r, http.StatusOK, "forgot.tmpl", data)
+}
+
+// userPasswordForgotPost emails a password reset link to the address given,
+// if there is an account for it. The response is the same either way, so that
+// it can't be used to find out who has an account.
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordForgotForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.tmpl", data)
+		return
+	}
+
+	user, token, err := app.passwordResets.Insert(r.Context(), form.Email, passwordResetTTL, passwordResetInterval)
+	switch {
+	case err == nil:
+		// Send the email in the background, so that the response doesn't
+		// take longer when the address has an account.
+		logger := app.requestLogger(r)
+		app.background(func() {
+			err := app.sendPasswordResetEmail(user, token)
+			if err != nil {
+				logger.Error("sending password reset email", slog.String("error", err.Error()))
+			}
+		})
+	case errors.Is(err, models.ErrNoRecord), errors.Is(err, models.ErrTooManyRequests):
+	default:
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If there is an account for that address, we've emailed it a link to reset the password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) sendPasswordResetEmail(user models.User, token string) error {
+	link := app.baseURL + "/user/password/reset?token=" + url.QueryEscape(token)
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Snippetbox password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password for your Snippetbox account. To choose a new password, follow this link:\n\n%s\n\n"+
+			"The link expires in 30 minutes and can only be used once. If you didn't ask to reset your password, you can ignore this email.\n",
+			user.Name, link),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	return app.mailer.Send(ctx, msg)
+}
+
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	valid, err := app.passwordResets.Valid(r.Context(), token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !valid {
+		app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired. Please ask for a new one.")
+		http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = passwordResetForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset.tmpl", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordResetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl", data)
+		return
+	}
+
+	_, err = app.passwordResets.Reset(r.Context(), form.Token, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired. Please ask for a new one.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// The reset has ended all of the user's sessions, so log this one out
+	// too, whoever it belonged to.
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
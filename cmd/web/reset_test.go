@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/mailer"
+)
+
+func TestUserPasswordForgot(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "<a href='/user/password/forgot'>")
+
+	_, _, body = ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	outbox := app.mailer.(*mailer.MemoryOutbox)
+
+	tests := []struct {
+		name         string
+		email        string
+		wantCode     int
+		wantMessages int
+	}{
+		{"Registered address", "alice@example.com", http.StatusSeeOther, 1},
+		{"Unregistered address", "nobody@example.com", http.StatusSeeOther, 1},
+		{"Invalid address", "alice@", http.StatusUnprocessableEntity, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, "/user/password/forgot", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			app.backgroundTasks.Wait()
+			assert.Equal(t, len(outbox.Messages()), tt.wantMessages)
+
+			// The response is the same whether or not the address has an
+			// account.
+			if code == http.StatusSeeOther {
+				assert.Equal(t, headers.Get("Location"), "/user/login")
+
+				_, _, body := ts.get(t, "/user/login")
+				assert.StringContains(t, body, "If there is an account for that address")
+			}
+		})
+	}
+
+	msg := outbox.Messages()[0]
+	assert.Equal(t, msg.To, "alice@example.com")
+	assert.StringContains(t, msg.Body, "https://snippetbox.example.com/user/password/reset?token=valid-reset-token")
+}
+
+func TestUserPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/user/password/reset?token=expired-token")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/password/forgot")
+
+	code, _, body := ts.get(t, "/user/password/reset?token=valid-reset-token")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<input type='hidden' name='token' value='valid-reset-token'>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		token        string
+		password     string
+		confirmation string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Short password",
+			token:        "valid-reset-token",
+			password:     "pa$$",
+			confirmation: "pa$$",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This field must be at least 8 characters long",
+		},
+		{
+			name:         "Mismatched confirmation",
+			token:        "valid-reset-token",
+			password:     "newPa$$word",
+			confirmation: "otherPa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Passwords do not match",
+		},
+		{
+			name:         "Invalid token",
+			token:        "expired-token",
+			password:     "newPa$$word",
+			confirmation: "newPa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/password/forgot",
+		},
+		{
+			name:         "Valid",
+			token:        "valid-reset-token",
+			password:     "newPa$$word",
+			confirmation: "newPa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("token", tt.token)
+			form.Add("newPassword", tt.password)
+			form.Add("newPasswordConfirmation", tt.confirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/user/password/reset", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAuthenticateSessionVersion(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isAuthenticated(r) {
+			w.Write([]byte("authenticated"))
+		}
+	})
+
+	tests := []struct {
+		name    string
+		version int
+		want    string
+	}{
+		{"Current version", 0, "authenticated"},
+		{"Old version", 1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			ctx, err := app.sessionManager.Load(r.Context(), "")
+			assert.NilError(t, err)
+			app.sessionManager.Put(ctx, "authenticatedUserID", 1)
+			app.sessionManager.Put(ctx, "sessionVersion", tt.version)
+
+			rr := httptest.NewRecorder()
+			app.authenticate(next).ServeHTTP(rr, r.WithContext(ctx))
+
+			assert.Equal(t, rr.Body.String(), tt.want)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/justinas/alice"
+	"snippetbox.dkimhw.com/internal/models"
 	"snippetbox.dkimhw.com/ui"
 )
 
@@ -22,6 +23,20 @@ func (app *application) routes() http.Handler {
 	mux.Handle("GET /static/", http.FileServerFS(ui.Files))
 
 	mux.HandleFunc("GET /ping", ping)
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+
+	// Feeds don't use sessions, so that feed readers aren't given a session
+	// cookie on every poll. The format is either "atom" or "rss".
+	mux.HandleFunc("GET /feed/{format}", app.feedLatest)
+	mux.HandleFunc("GET /user/{id}/feed/{format}", app.feedUser)
+	mux.HandleFunc("GET /tag/{tag}/feed/{format}", app.feedTag)
+
+	// The embed widget is displayed in frames on other sites, so it doesn't
+	// use sessions either, and allowFraming relaxes the anti-framing headers
+	// for the origins we trust.
+	mux.Handle("GET /embed/{id}", app.allowFraming(http.HandlerFunc(app.embedView)))
+	mux.HandleFunc("GET /oembed", app.oEmbed)
 
 	// Create a new middleware chain containing the middleware specific to our
 	// dynamic applicaiton routes. This middleware automatically loads and saves session data with every HTTP request and response.
@@ -29,26 +44,104 @@ func (app *application) routes() http.Handler {
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))
 	mux.Handle("GET /about", dynamic.ThenFunc(app.about))
+	mux.Handle("GET /popular", dynamic.ThenFunc(app.popular))
 	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(app.snippetView))
+	mux.Handle("GET /collection/{id}", dynamic.ThenFunc(app.collectionView))
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.userSignup))
 	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.userSignupPost))
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
+	mux.Handle("GET /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	mux.Handle("POST /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	mux.Handle("GET /user/verify", dynamic.ThenFunc(app.userVerify))
+	mux.Handle("GET /user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	mux.Handle("POST /user/password/forgot", dynamic.ThenFunc(app.userPasswordForgotPost))
+	mux.Handle("GET /user/password/reset", dynamic.ThenFunc(app.userPasswordReset))
+	mux.Handle("POST /user/password/reset", dynamic.ThenFunc(app.userPasswordResetPost))
 
 	// Protected application routes, using a new "protected" middleware chain which includes
 	// requireAuthentication middleware
 	protected := dynamic.Append(app.requireAuthentication)
+	// Publishing anything (including stars and collections), and setting up
+	// API tokens and webhooks, also requires a verified email address.
+	verified := protected.Append(app.requireVerifiedEmail)
 
-	mux.Handle("GET /snippet/create", protected.ThenFunc(app.snippetCreate))
-	mux.Handle("POST /snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	mux.Handle("GET /snippet/create", verified.ThenFunc(app.snippetCreate))
+	// The create form can upload files, so its body size is limited before
+	// noSurf gets to parse it while looking for the CSRF token.
+	upload := alice.New(app.limitRequestBody(maxCreateRequestSize)).Extend(verified)
+	mux.Handle("POST /snippet/create", upload.ThenFunc(app.snippetCreatePost))
+	mux.Handle("POST /snippet/fork/{id}", verified.ThenFunc(app.snippetForkPost))
+	mux.Handle("POST /snippet/star/{id}", verified.ThenFunc(app.snippetStarPost))
+	mux.Handle("POST /snippet/unstar/{id}", verified.ThenFunc(app.snippetUnstarPost))
+	mux.Handle("POST /snippet/comment/{id}", verified.ThenFunc(app.snippetCommentPost))
+	mux.Handle("GET /comment/{id}/edit", protected.ThenFunc(app.commentEdit))
+	mux.Handle("POST /comment/{id}/edit", protected.ThenFunc(app.commentEditPost))
+	mux.Handle("POST /comment/{id}/delete", protected.ThenFunc(app.commentDeletePost))
+	mux.Handle("POST /collection/{id}/snippets", verified.ThenFunc(app.collectionSnippetPost))
+	mux.Handle("POST /collection/{id}/delete", protected.ThenFunc(app.collectionDeletePost))
 	mux.Handle("GET /account/view", protected.ThenFunc(app.accountView))
 	mux.Handle("GET /account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
 	mux.Handle("POST /account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	mux.Handle("GET /account/2fa", protected.ThenFunc(app.accountTwoFactor))
+	mux.Handle("GET /account/2fa/qr.png", protected.ThenFunc(app.accountTwoFactorQR))
+	mux.Handle("POST /account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnablePost))
+	mux.Handle("POST /account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+	mux.Handle("POST /account/2fa/recovery-codes", protected.ThenFunc(app.accountTwoFactorRecoveryPost))
+	mux.Handle("GET /account/stars", protected.ThenFunc(app.accountStars))
+	mux.Handle("GET /account/collections", protected.ThenFunc(app.accountCollections))
+	mux.Handle("POST /account/collections", verified.ThenFunc(app.accountCollectionCreatePost))
+	mux.Handle("GET /account/tokens", protected.ThenFunc(app.accountTokens))
+	mux.Handle("POST /account/tokens", verified.ThenFunc(app.accountTokenCreatePost))
+	mux.Handle("POST /account/tokens/{id}/revoke", protected.ThenFunc(app.accountTokenRevokePost))
+	mux.Handle("GET /account/webhooks", protected.ThenFunc(app.accountWebhooks))
+	mux.Handle("POST /account/webhooks", verified.ThenFunc(app.accountWebhookCreatePost))
+	mux.Handle("GET /account/webhooks/{id}", protected.ThenFunc(app.accountWebhookView))
+	mux.Handle("POST /account/webhooks/{id}/test", protected.ThenFunc(app.accountWebhookTestPost))
+	mux.Handle("POST /account/webhooks/{id}/delete", protected.ThenFunc(app.accountWebhookDeletePost))
+	mux.Handle("POST /user/verify/resend", protected.ThenFunc(app.userVerifyResendPost))
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
 
-	// Create a standard reusable middleware chain
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	// The JSON API is authenticated with personal access tokens rather than
+	// sessions, so it doesn't need the session or noSurf middleware. Each route
+	// requires the token scope for what it does.
+	api := alice.New(app.authenticateToken)
+	apiRead := api.Append(app.requireScope(models.ScopeRead))
+	apiWrite := api.Append(app.requireAPIAuthentication, app.requireScope(models.ScopeWrite))
+	apiAdmin := api.Append(app.requireAPIAuthentication, app.requireScope(models.ScopeAdmin))
+
+	mux.Handle("GET /api/v1/snippets", apiRead.ThenFunc(app.apiSnippetList))
+	mux.Handle("GET /api/v1/snippets/{id}", apiRead.ThenFunc(app.apiSnippetView))
+	mux.Handle("POST /api/v1/snippets", apiWrite.ThenFunc(app.apiSnippetCreate))
+	mux.Handle("PUT /api/v1/snippets/{id}", apiWrite.ThenFunc(app.apiSnippetUpdate))
+	mux.Handle("DELETE /api/v1/snippets/{id}", apiWrite.ThenFunc(app.apiSnippetDelete))
+	mux.Handle("GET /api/v1/tokens", apiAdmin.ThenFunc(app.apiTokenList))
+	mux.Handle("DELETE /api/v1/tokens/{id}", apiAdmin.ThenFunc(app.apiTokenDelete))
+
+	// The paste endpoint takes a raw text body, for use with curl. It works
+	// with or without a token, but anonymous pastes are more strictly rate
+	// limited.
+	paste := api.Append(app.requireScope(models.ScopeWrite), app.limitPaste)
+
+	mux.Handle("POST /paste", paste.ThenFunc(app.pastePost))
+
+	// Create a standard reusable middleware chain. The tracing and metrics
+	// middleware go first so that they also see the 500 responses sent by
+	// recoverPanic, and requestID comes before anything which might log.
+	standard := alice.New(traceRequest(mux), app.metrics.instrument(mux), app.requestID, app.recoverPanic, app.logRequest, commonHeaders)
 
 	// use commonHeaders middleware
 	return standard.Then(mux)
 }
+
+// The adminRoutes() method returns the handler for the admin listener, which
+// is kept separate from the public routes.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", app.metrics.handler())
+	mux.Handle("GET /log-level", app.requireAdminToken(http.HandlerFunc(app.logLevelView)))
+	mux.Handle("PUT /log-level", app.requireAdminToken(http.HandlerFunc(app.logLevelUpdate)))
+
+	return app.recoverPanic(mux)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidSignedToken = errors.New("signed token is invalid")
+	errExpiredSignedToken = errors.New("signed token has expired")
+)
+
+// A signer creates and checks tokens which are signed with a secret key, such
+// as the ones in email verification links. Tokens aren't stored anywhere:
+// they say which user they are for and when they expire, and the signature
+// stops them from being forged or altered.
+//
+// Each token is for a purpose, so that a token made for one thing can't be
+// used for another, and is bound to a value which the caller looks up again
+// when checking it (such as the user's email address). Changing that value
+// invalidates the token.
+type signer struct {
+	key []byte
+}
+
+// sign returns a token for the user which expires after ttl.
+func (s signer) sign(purpose string, userID int, binding string, ttl time.Duration) string {
+	payload := strconv.Itoa(userID) + "." + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return payload + "." + s.mac(purpose, payload, binding)
+}
+
+// userID returns the ID of the user a token says it is for, so that the caller
+// can look up the value it is bound to. The token hasn't been checked yet, so
+// the ID mustn't be trusted until verify() has been called.
+func (s signer) userID(token string) (int, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// verify checks that a token was made by sign() for the purpose and binding,
+// and hasn't expired.
+func (s signer) verify(token, purpose, binding string) error {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return errInvalidSignedToken
+	}
+	payload, mac := token[:i], token[i+1:]
+
+	if !hmac.Equal([]byte(mac), []byte(s.mac(purpose, payload, binding))) {
+		return errInvalidSignedToken
+	}
+
+	_, expiry, _ := strings.Cut(payload, ".")
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errInvalidSignedToken
+	}
+	if time.Now().Unix() > expires {
+		return errExpiredSignedToken
+	}
+
+	return nil
+}
+
+func (s signer) mac(purpose, payload, binding string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + "\x00" + payload + "\x00" + binding))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
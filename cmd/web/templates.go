@@ -4,6 +4,7 @@ import (
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"snippetbox.dkimhw.com/internal/models"
@@ -15,14 +16,38 @@ import (
 // At the moment it only contains one field, but we'll add more
 // to it as the build progresses.
 type templateData struct {
-	CurrentYear     int
-	Snippet         models.Snippet
-	Snippets        []models.Snippet
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string // Add a CSRFToken field.
-	User            models.User
+	CurrentYear       int
+	Snippet           models.Snippet
+	Snippets          []models.Snippet
+	Forks             []models.Snippet // the snippets forked from Snippet
+	Starred           bool             // whether the current user has starred Snippet
+	Files             []fileView       // the files of Snippet, with their line comments
+	Threads           []commentThread  // the general comment threads on Snippet
+	OutdatedThreads   []commentThread  // line comment threads whose line has gone
+	CommentPages      pagination       // which page of Threads is shown
+	Comment           models.Comment
+	Collection        models.Collection
+	Collections       []models.Collection
+	Memberships       []collectionMembership // the current user's collections, on the view page
+	OwnsCollection    bool                   // whether the current user owns Collection
+	PopularSnippets   []models.PopularSnippet
+	Form              any
+	Flash             string
+	IsAuthenticated   bool
+	CSRFToken         string // Add a CSRFToken field.
+	User              models.User
+	Tokens            []models.Token
+	NewToken          models.Token
+	TOTPSecret        string   // the secret being set up, on the two-factor page
+	RecoveryCodes     []string // new recovery codes, only available once
+	RecoveryCodesLeft int
+	Webhooks          []models.Webhook
+	Webhook           models.Webhook
+	Deliveries        []models.WebhookDelivery
+	Languages         []string   // the languages which snippet files can be in
+	Feeds             []feedLink // for the autodiscovery links in base.tmpl
+	EmbedURL          string     // absolute URL of the embed widget for Snippet
+	OEmbedURL         string     // for the oEmbed discovery link in base.tmpl
 }
 
 func humanDate(t time.Time) string {
@@ -40,6 +65,9 @@ func humanDate(t time.Time) string {
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"contains":  slices.Contains[[]string],
+	"markdown":  renderMarkdown,
+	"comment":   renderComment,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -67,5 +95,24 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		cache[name] = ts
 	}
 
+	// Standalone pages, such as the embed widget, don't share the layout of
+	// the other pages. Each one is parsed on its own and defines its own
+	// "base" template.
+	standalone, err := fs.Glob(ui.Files, "html/standalone/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range standalone {
+		name := filepath.Base(page)
+
+		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, page)
+		if err != nil {
+			return nil, err
+		}
+
+		cache[name] = ts
+	}
+
 	return cache, nil
 }
@@ -10,11 +10,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"snippetbox.dkimhw.com/internal/mailer"
 	"snippetbox.dkimhw.com/internal/models/mocks"
 )
 
@@ -51,13 +53,37 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	webhooks := &mocks.WebhookModel{}
+	views := &mocks.ViewModel{}
+
+	// The webhook dispatcher and view counter aren't started, so no
+	// deliveries are made and no views are recorded unless a test runs them.
 	return &application{
-		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
-		snippets:       &mocks.SnippetModel{},
-		users:          &mocks.UserModel{},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		logger:            logger,
+		snippets:          &mocks.SnippetModel{},
+		users:             &mocks.UserModel{},
+		passwordResets:    &mocks.PasswordResetModel{},
+		twoFactor:         &mocks.TwoFactorModel{},
+		tokens:            &mocks.TokenModel{},
+		webhooks:          webhooks,
+		stars:             &mocks.StarModel{},
+		comments:          &mocks.CommentModel{},
+		collections:       &mocks.CollectionModel{},
+		views:             views,
+		templateCache:     templateCache,
+		formDecoder:       formDecoder,
+		sessionManager:    sessionManager,
+		metrics:           newMetrics(),
+		logLevel:          new(slog.LevelVar),
+		adminToken:        "admin-token",
+		mailer:            &mailer.MemoryOutbox{},
+		signer:            signer{key: []byte("test-secret-key")},
+		baseURL:           "https://snippetbox.example.com",
+		pasteLimiters:     newPasteLimiters(),
+		twoFactorLimiter:  newTwoFactorLimiter(),
+		webhookDispatcher: newWebhookDispatcher(webhooks, logger, true),
+		viewCounter:       newViewCounter(views, logger),
 	}
 }
 
@@ -133,3 +159,47 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers, and body
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// The do method sends a request with an arbitrary method, headers and body to
+// the test server, for cases which get() and postForm() don't cover.
+func (ts *testServer) do(t *testing.T, method, urlPath string, headers map[string]string, body string) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	respBody, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody = bytes.TrimSpace(respBody)
+
+	return rs.StatusCode, rs.Header, string(respBody)
+}
+
+// The login method logs in as the given user, so that later requests made by
+// the test server client are authenticated.
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
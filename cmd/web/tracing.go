@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The tracer for spans started by the web application. Like the one in the
+// models package it uses the global tracer provider, which is a no-op unless
+// newTracerProvider() has been called.
+var tracer = otel.Tracer("snippetbox.dkimhw.com/cmd/web")
+
+// newTracerProvider creates a tracer provider for the given exporter and
+// installs it (along with the W3C Trace Context propagator) as the global
+// one. The exporter is one of:
+//
+//   - "none": tracing is disabled and nil is returned
+//   - "stdout": spans are written to stdout as JSON
+//   - "file": spans are appended to the given file as JSON
+//   - "otlp": spans are sent to a collector over OTLP/HTTP, which is
+//     configured with the standard OTEL_EXPORTER_OTLP_* environment variables
+//
+// The caller must call Shutdown() on the returned provider before exiting, to
+// flush any spans which haven't been exported yet, and then call the returned
+// close function, which closes the file used by the "file" exporter.
+func newTracerProvider(exporter, file string) (_ *sdktrace.TracerProvider, closeFile func() error, err error) {
+	// Always propagate incoming trace context, so that our log entries and
+	// any downstream calls stay part of the caller's trace.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	closeFile = func() error { return nil }
+
+	var exp sdktrace.SpanExporter
+
+	switch exporter {
+	case "none":
+		return nil, closeFile, nil
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		var f *os.File
+		f, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeFile = f.Close
+		// Don't leave the file open if the provider can't be created.
+		defer func() {
+			if err != nil {
+				f.Close()
+			}
+		}()
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "snippetbox"),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp, closeFile, nil
+}
+
+// The traceRequest middleware starts a server span for every request, named
+// after the route pattern which it matches. If the request carries a W3C
+// traceparent header the span joins the caller's trace.
+func traceRequest(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", route),
+					attribute.String("url.path", r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			rw := newResponseRecorder(w)
+
+			next.ServeHTTP(rw, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.response.status_code", rw.status))
+			if rw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rw.status))
+			}
+		})
+	}
+}
+
+// recordSpanError marks the span as failed if err is non-nil.
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestTraceRequest(t *testing.T) {
+	// Install a tracer provider which records spans in memory. Note that the
+	// package-level tracers are bound to the first provider which is set, so
+	// this should be the only test which does this.
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+
+		// Every span should belong to the trace from the traceparent header.
+		assert.Equal(t, span.SpanContext().TraceID().String(), traceID)
+	}
+
+	assert.Equal(t, slices.Contains(names, "GET /snippet/view/{id}"), true)
+	assert.Equal(t, slices.Contains(names, "render view.tmpl"), true)
+}
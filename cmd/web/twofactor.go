@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/time/rate"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/validator"
+)
+
+const (
+	// The issuer shown next to the account in authenticator apps.
+	totpIssuer = "Snippetbox"
+	// TOTP codes change every totpPeriod, and codes from one period either
+	// side of the current one are accepted, to allow for clock drift.
+	totpPeriod = 30 * time.Second
+	// How long a user has to enter their code after entering their password.
+	twoFactorLoginTTL = 5 * time.Minute
+	// How many incorrect codes a user can enter before they have to enter
+	// their password again.
+	twoFactorMaxAttempts = 5
+)
+
+// newTwoFactorLimiter returns the rate limiter for the codes entered when
+// logging in, which is keyed by user: 5 codes at once, then one a minute.
+func newTwoFactorLimiter() *rateLimiter {
+	return newRateLimiter(rate.Every(time.Minute), twoFactorMaxAttempts)
+}
+
+var totpOpts = totp.ValidateOpts{
+	Period:    uint(totpPeriod.Seconds()),
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+type twoFactorCodeForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorPasswordForm is used by both the disable and regenerate recovery
+// codes forms on the two-factor page. Its errors are added to the
+// disablePassword or recoveryPassword field, so that they are shown next to
+// the right form.
+type twoFactorPasswordForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// totpStep returns the time step of the TOTP code for the secret at the
+// given time, if code is the code for the current step or the ones either
+// side of it.
+func totpStep(secret, code string, now time.Time) (int64, bool) {
+	for _, skew := range []time.Duration{0, -totpPeriod, totpPeriod} {
+		t := now.Add(skew)
+
+		want, err := totp.GenerateCodeCustom(secret, t, totpOpts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return t.Unix() / int64(totpPeriod.Seconds()), true
+		}
+	}
+
+	return 0, false
+}
+
+// totpKey returns the key for the user's TOTP secret, which is what the QR
+// code encodes.
+func totpKey(user models.User, secret string) (*otp.Key, error) {
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Email,
+		Period:      totpOpts.Period,
+		Secret:      raw,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+}
+
+// checkTwoFactorCode reports whether code is a valid TOTP or recovery code
+// for the user. Each code can only be used once. The second return value is
+// true if a recovery code was used.
+func (app *application) checkTwoFactorCode(ctx context.Context, userID int, code string) (bool, bool, error) {
+	code = strings.ReplaceAll(code, " ", "")
+
+	// TOTP codes are six digits; anything else is treated as a recovery
+	// code.
+	if len(code) == totpOpts.Digits.Length() && strings.Trim(code, "0123456789") == "" {
+		secret, err := app.twoFactor.Secret(ctx, userID)
+		if err != nil {
+			return false, false, err
+		}
+
+		step, ok := totpStep(secret, code, time.Now())
+		if !ok {
+			return false, false, nil
+		}
+
+		ok, err = app.twoFactor.UseStep(ctx, userID, step)
+		return ok, false, err
+	}
+
+	ok, err := app.twoFactor.UseRecoveryCode(ctx, userID, code)
+	return ok, ok, err
+}
+
+// pendingTwoFactorUserID returns the ID of the user who has entered their
+// password and still needs to enter a code, or 0 if there isn't one (or they
+// took too long).
+func (app *application) pendingTwoFactorUserID(r *http.Request) int {
+	expires := app.sessionManager.GetInt64(r.Context(), "pendingTwoFactorExpires")
+	if time.Now().Unix() > expires {
+		return 0
+	}
+
+	return app.sessionManager.GetInt(r.Context(), "pendingTwoFactorUserID")
+}
+
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "pendingTwoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "pendingTwoFactorExpires")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorCodeForm{}
+	app.render(w, r, http.StatusOK, "login2fa.tmpl", data)
+}
+
+// userLoginTwoFactorPost is the second step of logging in for users who have
+// enabled two-factor authentication. They are only logged in once they have
+// entered a valid code.
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUserID(r)
+	if id == 0 {
+		app.clearPendingTwoFactor(r)
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorCodeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login2fa.tmpl", data)
+		return
+	}
+
+	// After too many incorrect codes the user has to start again with their
+	// password. The session data isn't saved until the request has finished,
+	// though, so concurrent requests could get around this; the rate limit
+	// for the user is what actually stops codes from being guessed.
+	attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+	if attempts > twoFactorMaxAttempts {
+		app.clearPendingTwoFactor(r)
+		app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+	ok, _ := app.twoFactorLimiter.reserve("user:" + strconv.Itoa(id))
+	if !ok {
+		form.AddNonFieldError("Too many attempts. Please wait a minute and try again.")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login2fa.tmpl", data)
+		return
+	}
+
+	ok, usedRecovery, err := app.checkTwoFactorCode(r.Context(), id, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.metrics.logins.WithLabelValues("failure").Inc()
+		form.AddFieldError("code", "Code is incorrect")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login2fa.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.clearPendingTwoFactor(r)
+
+	if usedRecovery {
+		left, err := app.twoFactor.RecoveryCodesLeft(r.Context(), id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", recoveryCodesLeftMessage(left))
+	}
+
+	app.logIn(w, r, user)
+}
+
+func recoveryCodesLeftMessage(left int) string {
+	switch left {
+	case 0:
+		return "You have used your last recovery code. Please generate some new ones from your account page."
+	default:
+		return fmt.Sprintf("You have used a recovery code. You have %d left.", left)
+	}
+}
+
+// accountTwoFactor shows the two-factor authentication settings. If it hasn't
+// been enabled yet, a new TOTP secret is generated and kept in the session
+// until the user confirms that they have set up their authenticator app.
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTwoFactorPage(w, r, http.StatusOK, user, nil)
+}
+
+// renderTwoFactorPage renders the two-factor page for the user, with the
+// given form (or a blank one if it is nil).
+func (app *application) renderTwoFactorPage(w http.ResponseWriter, r *http.Request, status int, user models.User, form any) {
+	data := app.newTemplateData(r)
+	data.User = user
+
+	if user.TwoFactorEnabled {
+		left, err := app.twoFactor.RecoveryCodesLeft(r.Context(), user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.RecoveryCodesLeft = left
+
+		if form == nil {
+			form = twoFactorPasswordForm{}
+		}
+	} else {
+		secret := app.sessionManager.GetString(r.Context(), "totpEnrollmentSecret")
+		if secret == "" {
+			key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Email})
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			secret = key.Secret()
+			app.sessionManager.Put(r.Context(), "totpEnrollmentSecret", secret)
+		}
+		data.TOTPSecret = secret
+
+		if form == nil {
+			form = twoFactorCodeForm{}
+		}
+	}
+
+	data.Form = form
+	app.render(w, r, status, "twofactor.tmpl", data)
+}
+
+// accountTwoFactorQR serves the QR code for the TOTP secret being set up. It
+// is a separate image, rather than a data: URL in the page, because the
+// Content-Security-Policy only allows images from our own origin.
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpEnrollmentSecret")
+	if secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	key, err := totpKey(user, secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The image contains the secret, so it mustn't be cached.
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// accountTwoFactorEnablePost enables two-factor authentication once the user
+// has entered a code from their authenticator app, which shows that they have
+// set it up correctly, and shows them their recovery codes.
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var form twoFactorCodeForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	secret := app.sessionManager.GetString(r.Context(), "totpEnrollmentSecret")
+	if user.TwoFactorEnabled || secret == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	step, ok := totpStep(secret, strings.ReplaceAll(form.Code, " ", ""), time.Now())
+	if form.Valid() {
+		form.CheckField(ok, "code", "Code is incorrect. Check that your device's clock is right")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, user, form)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(r.Context(), user.ID, secret)
+	if err != nil {
+		if errors.Is(err, models.ErrTwoFactorEnabled) {
+			http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "totpEnrollmentSecret")
+
+	// Use up the code that was just entered, so that it can't also be used
+	// to log in.
+	_, err = app.twoFactor.UseStep(r.Context(), user.ID, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user.TwoFactorEnabled = true
+	app.renderRecoveryCodes(w, r, user, codes, "Two-factor authentication is now enabled.")
+}
+
+// accountTwoFactorDisablePost disables two-factor authentication, after
+// checking the user's password.
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	user, form, ok := app.twoFactorPasswordPost(w, r, "disablePassword")
+	if !ok {
+		return
+	}
+
+	err := app.twoFactor.Disable(r.Context(), user.ID, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("disablePassword", "Password is incorrect")
+			app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, user, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been disabled.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// accountTwoFactorRecoveryPost replaces the user's recovery codes, after
+// checking their password.
+func (app *application) accountTwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
+	user, form, ok := app.twoFactorPasswordPost(w, r, "recoveryPassword")
+	if !ok {
+		return
+	}
+
+	codes, err := app.twoFactor.RegenerateRecoveryCodes(r.Context(), user.ID, form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("recoveryPassword", "Password is incorrect")
+			app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, user, form)
+		case errors.Is(err, models.ErrNoRecord):
+			http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.renderRecoveryCodes(w, r, user, codes, "Your new recovery codes have been generated, and the old ones no longer work.")
+}
+
+// twoFactorPasswordPost decodes and validates a twoFactorPasswordForm for a
+// user who has enabled two-factor authentication, adding any errors to the
+// given field. If it returns false, a response has already been sent.
+func (app *application) twoFactorPasswordPost(w http.ResponseWriter, r *http.Request, field string) (models.User, twoFactorPasswordForm, bool) {
+	var form twoFactorPasswordForm
+
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return user, form, false
+	}
+
+	if !user.TwoFactorEnabled {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return user, form, false
+	}
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return user, form, false
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), field, "This field cannot be blank")
+
+	if !form.Valid() {
+		app.renderTwoFactorPage(w, r, http.StatusUnprocessableEntity, user, form)
+		return user, form, false
+	}
+
+	return user, form, true
+}
+
+// renderRecoveryCodes shows the user their new recovery codes. As with new
+// API tokens, the page is rendered directly rather than redirecting, because
+// this is the only time the codes are available.
+func (app *application) renderRecoveryCodes(w http.ResponseWriter, r *http.Request, user models.User, codes []string, flash string) {
+	data := app.newTemplateData(r)
+	data.User = user
+	data.RecoveryCodes = codes
+	data.RecoveryCodesLeft = len(codes)
+	data.Form = twoFactorPasswordForm{}
+	data.Flash = flash
+
+	app.render(w, r, http.StatusOK, "twofactor.tmpl", data)
+}
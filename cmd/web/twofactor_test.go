@@ -0,0 +1,293 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+// Captures the TOTP secret shown on the two-factor page.
+var totpSecretRX = regexp.MustCompile(`Key: <code>([A-Z2-7]+)</code>`)
+
+func totpCode(t *testing.T, secret string, now time.Time) string {
+	code, err := totp.GenerateCodeCustom(secret, now, totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+// loginTwoFactor logs in as the mock user who has enabled two-factor
+// authentication, using the given code for the second step.
+func (ts *testServer) loginTwoFactor(t *testing.T, code string) {
+	ts.login(t, "dave@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/user/login/2fa")
+
+	form := url.Values{}
+	form.Add("code", code)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	status, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	if status != http.StatusSeeOther {
+		t.Fatalf("two-factor login failed with status %d", status)
+	}
+}
+
+func TestTOTPStep(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		wantOK bool
+	}{
+		{"Current code", now, true},
+		{"Previous code", now.Add(-totpPeriod), true},
+		{"Next code", now.Add(totpPeriod), true},
+		{"Old code", now.Add(-3 * totpPeriod), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := totpStep(mocks.TOTPSecret, totpCode(t, mocks.TOTPSecret, tt.at), now)
+			assert.Equal(t, ok, tt.wantOK)
+
+			if ok {
+				assert.Equal(t, step, tt.at.Unix()/30)
+			}
+		})
+	}
+
+	_, ok := totpStep(mocks.TOTPSecret, "not a code", now)
+	assert.Equal(t, ok, false)
+}
+
+func TestUserLoginTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The second step can't be reached without entering a password first.
+	code, headers, _ := ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "dave@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, headers, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+
+	// The user isn't logged in until they have entered a code.
+	code, headers, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken := extractCSRFToken(t, body)
+	validCode := totpCode(t, mocks.TOTPSecret, time.Now())
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{"Blank", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Wrong code", "000000", http.StatusUnprocessableEntity, "Code is incorrect"},
+		{"Wrong recovery code", "zzzz-zzzz", http.StatusUnprocessableEntity, "Code is incorrect"},
+		{"Valid code", validCode, http.StatusSeeOther, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	code, _, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+
+	// The same code can't be used to log in again, but a recovery code can.
+	_, _, body = ts.get(t, "/")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/logout", form)
+
+	ts.login(t, "dave@example.com", "pa$$word")
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+
+	form = url.Values{}
+	form.Add("code", validCode)
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	form.Set("code", "ABCD EFGH")
+	code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "You have used your last recovery code.")
+}
+
+func TestUserLoginTwoFactorAttempts(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "dave@example.com", "pa$$word")
+	_, _, body := ts.get(t, "/user/login/2fa")
+
+	form := url.Values{}
+	form.Add("code", "000000")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	for range twoFactorMaxAttempts {
+		code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// After too many attempts, even the right code isn't accepted and the
+	// user has to enter their password again.
+	form.Set("code", totpCode(t, mocks.TOTPSecret, time.Now()))
+
+	code, headers, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	code, _, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Logging in again starts a new session counter, but the rate limit for
+	// the user still applies.
+	ts.login(t, "dave@example.com", "pa$$word")
+
+	code, _, body = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.StringContains(t, body, "Too many attempts")
+}
+
+func TestAccountTwoFactorEnable(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/view")
+	assert.StringContains(t, body, "<a href=\"/account/2fa\">Set up</a>")
+
+	code, _, body = ts.get(t, "/account/2fa")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<img src='/account/2fa/qr.png'")
+
+	matches := totpSecretRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no TOTP secret found in body")
+	}
+	secret := matches[1]
+	csrfToken := extractCSRFToken(t, body)
+
+	// The same secret is shown until two-factor authentication is enabled.
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, secret)
+
+	code, headers, _ := ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+	assert.Equal(t, headers.Get("Cache-Control"), "no-store")
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{"Blank", "", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Wrong code", "000000", http.StatusUnprocessableEntity, "Code is incorrect"},
+		{"Valid code", totpCode(t, secret, time.Now()), http.StatusOK, "<code>ijkl-mnop</code>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	// The secret has been removed from the session.
+	code, _, _ = ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAccountTwoFactorPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginTwoFactor(t, totpCode(t, mocks.TOTPSecret, time.Now()))
+
+	_, _, body := ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, "You have 1 unused recovery code.")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"Regenerate with wrong password", "/account/2fa/recovery-codes", "wrong", http.StatusUnprocessableEntity, "", "Password is incorrect"},
+		{"Regenerate", "/account/2fa/recovery-codes", "pa$$word", http.StatusOK, "", "<code>ijkl-mnop</code>"},
+		{"Disable with blank password", "/account/2fa/disable", "", http.StatusUnprocessableEntity, "", "This field cannot be blank"},
+		{"Disable with wrong password", "/account/2fa/disable", "wrong", http.StatusUnprocessableEntity, "", "Password is incorrect"},
+		{"Disable", "/account/2fa/disable", "pa$$word", http.StatusSeeOther, "/account/view", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
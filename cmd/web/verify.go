@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/mailer"
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+const (
+	// The purpose of the signed tokens in verification links.
+	purposeVerifyEmail = "verify-email"
+	// How long a verification link can be used for.
+	verificationTTL = 24 * time.Hour
+	// How long a user has to wait before another verification email can be
+	// sent to them.
+	verificationResendInterval = 2 * time.Minute
+	// How long to wait for the mailer to send an email.
+	mailTimeout = 10 * time.Second
+)
+
+// sendVerificationEmail emails the user a link to verify their address,
+// unless one was sent to them in the last verificationResendInterval. It
+// returns whether an email was sent.
+func (app *application) sendVerificationEmail(r *http.Request, user models.User) (bool, error) {
+	ok, err := app.users.ReserveVerificationEmail(r.Context(), user.ID, verificationResendInterval)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	token := app.signer.sign(purposeVerifyEmail, user.ID, user.Email, verificationTTL)
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please verify your email address by following this link:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't sign up for Snippetbox, you can ignore this email.\n",
+			user.Name, link),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), mailTimeout)
+	defer cancel()
+
+	err = app.mailer.Send(ctx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// userVerify verifies the email address of the user a verification link was
+// sent to. The link works whether or not the user is logged in.
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	user, err := app.verificationUser(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidSignedToken):
+			app.sessionManager.Put(r.Context(), "flash", "That verification link is invalid.")
+		case errors.Is(err, errExpiredSignedToken):
+			app.sessionManager.Put(r.Context(), "flash", "That verification link has expired. Please log in to get a new one.")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.VerifyEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified.")
+
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// verificationUser returns the user a verification token was made for. It
+// returns errInvalidSignedToken if the token is invalid, including if the
+// user has changed their email address since it was made.
+func (app *application) verificationUser(ctx context.Context, token string) (models.User, error) {
+	id, ok := app.signer.userID(token)
+	if !ok {
+		return models.User{}, errInvalidSignedToken
+	}
+
+	user, err := app.users.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return models.User{}, errInvalidSignedToken
+		}
+		return models.User{}, err
+	}
+
+	err = app.signer.verify(token, purposeVerifyEmail, user.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// userVerifyResendPost sends the current user another verification email.
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address has already been verified.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	sent, err := app.sendVerificationEmail(r, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if sent {
+		app.sessionManager.Put(r.Context(), "flash", "We've sent you another verification email.")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "A verification email was sent recently. Please wait a couple of minutes before asking for another.")
+	}
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
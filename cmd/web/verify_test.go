@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/mailer"
+)
+
+func TestSigner(t *testing.T) {
+	s := signer{key: []byte("secret")}
+
+	token := s.sign("verify-email", 3, "carol@example.com", time.Hour)
+
+	id, ok := s.userID(token)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, id, 3)
+	assert.NilError(t, s.verify(token, "verify-email", "carol@example.com"))
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose string
+		binding string
+		want    error
+	}{
+		{"Other purpose", token, "reset-password", "carol@example.com", errInvalidSignedToken},
+		{"Changed binding", token, "verify-email", "carol@example.org", errInvalidSignedToken},
+		{"Changed user", "4" + token[1:], "verify-email", "carol@example.com", errInvalidSignedToken},
+		{"Other key", signer{key: []byte("other")}.sign("verify-email", 3, "carol@example.com", time.Hour), "verify-email", "carol@example.com", errInvalidSignedToken},
+		{"Expired", s.sign("verify-email", 3, "carol@example.com", -time.Minute), "verify-email", "carol@example.com", errExpiredSignedToken},
+		{"Malformed", "nonsense", "verify-email", "carol@example.com", errInvalidSignedToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, s.verify(tt.token, tt.purpose, tt.binding), tt.want)
+		})
+	}
+}
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+
+	form := url.Values{}
+	form.Add("name", "Dave")
+	form.Add("email", "dave@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	messages := app.mailer.(*mailer.MemoryOutbox).Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].To, "dave@example.com")
+	assert.StringContains(t, messages[0].Body, "https://snippetbox.example.com/user/verify?token=4.")
+}
+
+func TestUserVerify(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		token     string
+		wantPath  string
+		wantFlash string
+	}{
+		{
+			name:      "Valid",
+			token:     app.signer.sign(purposeVerifyEmail, 3, "carol@example.com", time.Hour),
+			wantPath:  "/user/login",
+			wantFlash: "Your email address has been verified.",
+		},
+		{
+			name:      "Expired",
+			token:     app.signer.sign(purposeVerifyEmail, 3, "carol@example.com", -time.Hour),
+			wantPath:  "/",
+			wantFlash: "That verification link has expired.",
+		},
+		{
+			name:      "Old email address",
+			token:     app.signer.sign(purposeVerifyEmail, 3, "carol@example.org", time.Hour),
+			wantPath:  "/",
+			wantFlash: "That verification link is invalid.",
+		},
+		{
+			name:      "Non-existent user",
+			token:     app.signer.sign(purposeVerifyEmail, 99, "carol@example.com", time.Hour),
+			wantPath:  "/",
+			wantFlash: "That verification link is invalid.",
+		},
+		{
+			name:      "Missing token",
+			wantPath:  "/",
+			wantFlash: "That verification link is invalid.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.get(t, "/user/verify?token="+url.QueryEscape(tt.token))
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), tt.wantPath)
+
+			_, _, body := ts.get(t, tt.wantPath)
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+}
+
+func TestUnverifiedUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "carol@example.com", "pa$$word")
+
+	// Unverified users can look around, but can't publish anything.
+	code, _, body := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<span class='unverified'>(not verified)</span>")
+	csrfToken := extractCSRFToken(t, body)
+
+	code, headers, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	form := url.Values{}
+	form.Add("body", "First!")
+	form.Add("csrf_token", csrfToken)
+
+	code, headers, _ = ts.postForm(t, "/snippet/comment/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	// Stars and collections are public too.
+	for _, urlPath := range []string{"/snippet/star/1", "/snippet/unstar/1", "/account/collections", "/collection/1/snippets"} {
+		form := url.Values{}
+		form.Add("name", "Poems")
+		form.Add("snippet_id", "1")
+		form.Add("csrf_token", csrfToken)
+
+		code, headers, _ = ts.postForm(t, urlPath, form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/account/view")
+	}
+
+	// They can ask for another verification email, but not straight away
+	// after that.
+	outbox := app.mailer.(*mailer.MemoryOutbox)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	ts.postForm(t, "/user/verify/resend", form)
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "We&#39;ve sent you another verification email.")
+	assert.Equal(t, len(outbox.Messages()), 1)
+
+	ts.postForm(t, "/user/verify/resend", form)
+	_, _, body = ts.get(t, "/account/view")
+	assert.StringContains(t, body, "A verification email was sent recently.")
+	assert.Equal(t, len(outbox.Messages()), 1)
+
+	// The link in the email verifies the address.
+	link := outbox.Messages()[0].Body
+	link = link[strings.Index(link, "/user/verify?"):]
+	link = link[:strings.IndexByte(link, '\n')]
+
+	code, headers, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+}
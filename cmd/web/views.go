@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+const (
+	// How often the buffered view counts are written to the database.
+	viewFlushInterval = 10 * time.Second
+	// How long a viewer's repeat views of a snippet are ignored for. This is
+	// the same as the session lifetime.
+	viewDedupWindow = 12 * time.Hour
+	// The most views the viewCounter remembers for deduplication. Once it is
+	// full, further views are still counted but not remembered, so memory use
+	// stays bounded however busy the site is.
+	viewDedupMaxEntries = 100_000
+	// The popular page ranks snippets by their views over this many days.
+	popularDays  = 7
+	popularLimit = 20
+)
+
+// A viewCounter counts snippet views in memory and writes them to the
+// database in batches, so that viewing a snippet doesn't need a write of its
+// own. Counts which haven't been flushed when the application stops are
+// flushed as it shuts down; if a flush fails they are lost, as view counts
+// only need to be approximately right.
+type viewCounter struct {
+	views  models.ViewModelInterface
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	pending   map[int]int           // new views, keyed by snippet ID
+	seen      map[viewKey]time.Time // when each viewer first viewed each snippet
+	lastSweep time.Time
+}
+
+type viewKey struct {
+	viewer    string
+	snippetID int
+}
+
+func newViewCounter(views models.ViewModelInterface, logger *slog.Logger) *viewCounter {
+	return &viewCounter{
+		views:     views,
+		logger:    logger,
+		pending:   make(map[int]int),
+		seen:      make(map[viewKey]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// add counts a view of a snippet, unless the viewer has already viewed it
+// within viewDedupWindow.
+func (c *viewCounter) add(viewer string, snippetID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// As with rateLimiter, old entries are removed every so often here rather
+	// than in the background.
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, t := range c.seen {
+			if now.Sub(t) > viewDedupWindow {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	key := viewKey{viewer: viewer, snippetID: snippetID}
+	if t, ok := c.seen[key]; ok && now.Sub(t) <= viewDedupWindow {
+		return
+	}
+	if len(c.seen) < viewDedupMaxEntries {
+		c.seen[key] = now
+	}
+
+	c.pending[snippetID]++
+}
+
+// run flushes the view counts every viewFlushInterval until ctx is
+// cancelled, and then flushes them one last time.
+func (c *viewCounter) run(ctx context.Context) {
+	ticker := time.NewTicker(viewFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx has been cancelled, so give the final flush a context of
+			// its own.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			c.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			c.flush(ctx)
+		}
+	}
+}
+
+// flush writes the pending view counts to the database.
+func (c *viewCounter) flush(ctx context.Context) {
+	c.mu.Lock()
+	counts := c.pending
+	c.pending = make(map[int]int)
+	c.mu.Unlock()
+
+	err := c.views.Record(ctx, counts)
+	if err != nil {
+		c.logger.Error("recording snippet views", slog.String("error", err.Error()), slog.Int("snippets", len(counts)))
+	}
+}
+
+// countView counts a view of a snippet. Viewers are identified by their
+// session if they have one, and otherwise by their IP address.
+func (app *application) countView(r *http.Request, snippetID int) {
+	viewer := app.sessionManager.Token(r.Context())
+	if viewer == "" {
+		viewer = "ip:" + clientIP(r)
+	}
+
+	app.viewCounter.add(viewer, snippetID)
+}
+
+// popular shows the snippets which have been viewed the most over the last
+// week.
+func (app *application) popular(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.views.Popular(r.Context(), popularDays, popularLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.PopularSnippets = snippets
+
+	app.render(w, r, http.StatusOK, "popular.tmpl", data)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+func TestSnippetViewCounts(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "3 views")
+
+	// Repeat views from the same viewer are only counted once, and views of
+	// snippets which don't exist aren't counted at all.
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/1.json")
+	ts.get(t, "/snippet/view/2")
+
+	views := app.views.(*mocks.ViewModel)
+	assert.Equal(t, len(views.Recorded()), 0)
+
+	app.viewCounter.flush(context.Background())
+	assert.Equal(t, views.Recorded()[1], 1)
+	assert.Equal(t, views.Recorded()[2], 0)
+
+	// Once flushed, the counts aren't recorded again.
+	app.viewCounter.flush(context.Background())
+	assert.Equal(t, views.Recorded()[1], 1)
+}
+
+func TestViewCounterDedup(t *testing.T) {
+	app := newTestApplication(t)
+	c := app.viewCounter
+
+	c.add("alice", 1)
+	c.add("alice", 1)
+	c.add("bob", 1)
+	c.add("alice", 2)
+
+	assert.Equal(t, c.pending[1], 2)
+	assert.Equal(t, c.pending[2], 1)
+}
+
+func TestPopular(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/popular")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silent pond</a>")
+	assert.StringContains(t, body, "<td>2</td>")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+const (
+	// How many deliveries to claim from the queue at a time. The deliveries
+	// in a batch are sent concurrently.
+	webhookBatchSize = 10
+	// How long a claimed delivery is reserved for. This must be longer than
+	// webhookTimeout, so that a delivery isn't claimed again while it is still
+	// in progress.
+	webhookLease = time.Minute
+	// How long to wait for a receiver to respond.
+	webhookTimeout = 10 * time.Second
+	// How often to check the queue for deliveries which are due to be
+	// retried. New events wake the dispatcher straight away.
+	webhookPollInterval = 5 * time.Second
+	// Failed deliveries are retried after 30s, 1m, 2m, 4m and so on, until
+	// they have been attempted webhookMaxAttempts times (about an hour in
+	// total).
+	webhookRetryDelay  = 30 * time.Second
+	webhookMaxAttempts = 8
+)
+
+var errPrivateAddress = errors.New("webhook: connecting to private network addresses is not allowed")
+
+// The webhookDispatcher delivers the queued webhook events in the background.
+// The queue is stored in the database, so deliveries which haven't been made
+// when the application stops are made once it starts again.
+type webhookDispatcher struct {
+	webhooks models.WebhookModelInterface
+	client   *http.Client
+	logger   *slog.Logger
+	wakeup   chan struct{}
+}
+
+// newWebhookDispatcher returns a dispatcher for the queue in webhooks. Unless
+// allowPrivate is true it refuses to connect to loopback and private network
+// addresses, so that webhooks can't be used to probe our internal network.
+func newWebhookDispatcher(webhooks models.WebhookModelInterface, logger *slog.Logger, allowPrivate bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = blockPrivateAddresses
+	}
+
+	// Don't use a proxy, as the address checks would then apply to the proxy
+	// rather than the receiver.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &webhookDispatcher{
+		webhooks: webhooks,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   webhookTimeout,
+			// Don't follow redirects. A redirect response counts as a failed
+			// delivery, so users can see that they need to update the URL.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// run delivers webhooks until ctx is cancelled.
+func (d *webhookDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := d.deliverDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("delivering webhooks", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wakeup:
+		}
+	}
+}
+
+// wake tells the dispatcher that new deliveries have been queued. It never
+// blocks.
+func (d *webhookDispatcher) wake() {
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDue attempts each of the deliveries which are due, until there are
+// none left.
+func (d *webhookDispatcher) deliverDue(ctx context.Context) error {
+	for {
+		deliveries, err := d.webhooks.Claim(ctx, webhookBatchSize, webhookLease)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// deliver makes one attempt at a delivery and records the outcome.
+func (d *webhookDispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	attempt := d.attempt(ctx, delivery)
+
+	if !attempt.Delivered && delivery.Attempts+1 < webhookMaxAttempts {
+		attempt.NextAttempt = attempt.Time.Add(retryDelay(delivery.Attempts + 1))
+	}
+
+	// Record the outcome even if we're shutting down, otherwise the delivery
+	// would be sent again when its lease expires.
+	err := d.webhooks.RecordAttempt(context.WithoutCancel(ctx), delivery.ID, attempt)
+	if err != nil {
+		d.logger.Error("recording webhook attempt", slog.Int("delivery_id", delivery.ID), slog.String("error", err.Error()))
+	}
+}
+
+// attempt sends the delivery's payload to the webhook URL.
+func (d *webhookDispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) models.WebhookAttempt {
+	attempt := models.WebhookAttempt{Time: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	timestamp := strconv.FormatInt(attempt.Time.Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Snippetbox-Webhook/1.0")
+	req.Header.Set("X-Snippetbox-Event", delivery.Event)
+	req.Header.Set("X-Snippetbox-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Snippetbox-Timestamp", timestamp)
+	req.Header.Set("X-Snippetbox-Signature", "sha256="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	attempt.Duration = time.Since(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	// Read (some of) the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.ResponseCode = resp.StatusCode
+	attempt.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !attempt.Delivered {
+		attempt.Error = fmt.Sprintf("unexpected response status %s", resp.Status)
+	}
+
+	return attempt
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of the timestamp and
+// payload, joined by a ".". Including the timestamp lets receivers reject
+// old requests which are being replayed.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns how long to wait before the next attempt at a delivery
+// which has failed the given number of times.
+func retryDelay(attempts int) time.Duration {
+	return webhookRetryDelay << (attempts - 1)
+}
+
+// blockPrivateAddresses is a net.Dialer Control function which refuses to
+// connect to loopback, private and link-local addresses. Checking the address
+// which is actually dialled, rather than the URL's host, means that it can't
+// be sidestepped with DNS names which resolve to internal addresses.
+func blockPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// webhookPayload is the JSON body sent to webhooks.
+type webhookPayload struct {
+	Event     string          `json:"event"`
+	Created   time.Time       `json:"created"`
+	Snippet   *models.Snippet `json:"snippet,omitempty"`
+	Comment   *models.Comment `json:"comment,omitempty"`    // only for comment events
+	WebhookID int             `json:"webhook_id,omitempty"` // only for ping events
+}
+
+// notifySnippetEvent queues the event for the webhooks of the snippet's
+// owner. Anonymous snippets don't have an owner, so nobody is notified about
+// them. Failing to queue an event shouldn't fail the request which caused it,
+// so errors are logged rather than returned.
+func (app *application) notifySnippetEvent(r *http.Request, event string, snippet models.Snippet) {
+	if snippet.UserID == 0 {
+		return
+	}
+
+	app.queueWebhookEvent(r, snippet.UserID, webhookPayload{
+		Event:   event,
+		Created: time.Now().UTC(),
+		Snippet: &snippet,
+	})
+}
+
+// notifyCommentCreated tells the snippet's owner about a new comment, unless
+// they wrote it themselves. The comment.created event is sent to their
+// webhooks, and they are also emailed, so that owners hear about comments
+// whether or not they have set up a webhook. page is the page of comments
+// which the comment is on.
+func (app *application) notifyCommentCreated(r *http.Request, snippet models.Snippet, commentID, page int) {
+	comment, err := app.comments.Get(r.Context(), commentID)
+	if err != nil {
+		app.requestLogger(r).Error("fetching new comment for notifications", slog.Int("comment_id", commentID), slog.String("error", err.Error()))
+		return
+	}
+
+	if snippet.UserID == 0 || snippet.UserID == comment.UserID {
+		return
+	}
+
+	app.queueWebhookEvent(r, snippet.UserID, webhookPayload{
+		Event:   models.EventCommentCreated,
+		Created: time.Now().UTC(),
+		Snippet: &snippet,
+		Comment: &comment,
+	})
+
+	app.emailCommentCreated(r, snippet, comment, page)
+}
+
+// queueWebhookEvent queues the payload for the user's webhooks which subscribe
+// to its event, and wakes the dispatcher if there are any.
+func (app *application) queueWebhookEvent(r *http.Request, userID int, p webhookPayload) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		app.requestLogger(r).Error("encoding webhook payload", slog.String("error", err.Error()))
+		return
+	}
+
+	n, err := app.webhooks.Enqueue(r.Context(), userID, p.Event, payload)
+	if err != nil {
+		app.requestLogger(r).Error("queueing webhook event", slog.String("event", p.Event), slog.String("error", err.Error()))
+		return
+	}
+
+	if n > 0 {
+		app.webhookDispatcher.wake()
+	}
+}
+
+// notifySnippetCreated fetches a newly created snippet and sends the
+// snippet.created event for it.
+func (app *application) notifySnippetCreated(r *http.Request, id int) {
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		app.requestLogger(r).Error("fetching new snippet for webhooks", slog.Int("snippet_id", id), slog.String("error", err.Error()))
+		return
+	}
+
+	app.notifySnippetEvent(r, models.EventSnippetCreated, snippet)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+	"snippetbox.dkimhw.com/internal/models"
+	"snippetbox.dkimhw.com/internal/models/mocks"
+)
+
+func TestAccountWebhooks(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/webhooks")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<a href='/account/webhooks/1'>https://example.com/hook</a>")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		url          string
+		events       []string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid submission",
+			url:          "https://example.com/other-hook",
+			events:       []string{"snippet.created", "snippet.deleted"},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/webhooks/2",
+		},
+		{
+			name:     "Invalid URL",
+			url:      "ftp://example.com/hook",
+			events:   []string{"snippet.created"},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a valid http or https URL",
+		},
+		{
+			name:     "No events",
+			url:      "https://example.com/hook",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "You must choose at least one event",
+		},
+		{
+			name:     "Unknown event",
+			url:      "https://example.com/hook",
+			events:   []string{"user.created"},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field contains an unknown event",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("url", tt.url)
+			for _, event := range tt.events {
+				form.Add("events", event)
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, body := ts.postForm(t, "/account/webhooks", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountWebhookView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/webhooks/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<code>0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef</code>")
+	assert.StringContains(t, body, "<td class='delivered'>delivered</td>")
+	assert.StringContains(t, body, "<td>42ms</td>")
+	csrfToken := extractCSRFToken(t, body)
+
+	code, _, _ = ts.get(t, "/account/webhooks/2")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Send test event",
+			urlPath:      "/account/webhooks/1/test",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/webhooks/1",
+		},
+		{
+			name:     "Send test event to another user's webhook",
+			urlPath:  "/account/webhooks/2/test",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Delete",
+			urlPath:      "/account/webhooks/1/delete",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/webhooks",
+		},
+		{
+			name:     "Delete another user's webhook",
+			urlPath:  "/account/webhooks/2/delete",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+// fakeWebhookQueue is an in-memory webhook delivery queue. Each delivery can
+// be claimed once, and the attempts made at it are recorded.
+type fakeWebhookQueue struct {
+	mocks.WebhookModel
+	mu         sync.Mutex
+	deliveries []models.WebhookDelivery
+	attempts   map[int]models.WebhookAttempt
+}
+
+func (q *fakeWebhookQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(limit, len(q.deliveries))
+	claimed := q.deliveries[:n]
+	q.deliveries = q.deliveries[n:]
+	return claimed, nil
+}
+
+func (q *fakeWebhookQueue) RecordAttempt(ctx context.Context, id int, attempt models.WebhookAttempt) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.attempts[id] = attempt
+	return nil
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	const secret = "s3cret"
+
+	var (
+		mu       sync.Mutex
+		received []*http.Request
+	)
+
+	// The receiver checks the signature the way a real one would, and fails
+	// requests for /broken.
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		want := "sha256=" + signWebhook(secret, r.Header.Get("X-Snippetbox-Timestamp"), body)
+		if !hmac.Equal([]byte(r.Header.Get("X-Snippetbox-Signature")), []byte(want)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, r)
+		mu.Unlock()
+
+		if r.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	payload, err := json.Marshal(webhookPayload{Event: models.EventPing, WebhookID: 1})
+	assert.NilError(t, err)
+
+	queue := &fakeWebhookQueue{
+		deliveries: []models.WebhookDelivery{
+			{ID: 1, URL: receiver.URL + "/hook", Secret: secret, Event: models.EventPing, Payload: payload},
+			{ID: 2, URL: receiver.URL + "/broken", Secret: secret, Event: models.EventPing, Payload: payload, Attempts: 2},
+			{ID: 3, URL: receiver.URL + "/broken", Secret: secret, Event: models.EventPing, Payload: payload, Attempts: webhookMaxAttempts - 1},
+			{ID: 4, URL: receiver.URL + "/hook", Secret: "wrong", Event: models.EventPing, Payload: payload},
+		},
+		attempts: map[int]models.WebhookAttempt{},
+	}
+
+	d := newWebhookDispatcher(queue, slog.New(slog.NewTextHandler(io.Discard, nil)), true)
+
+	err = d.deliverDue(context.Background())
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(received), 3)
+	for _, r := range received {
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+		assert.Equal(t, r.Header.Get("X-Snippetbox-Event"), models.EventPing)
+	}
+
+	t.Run("Delivered", func(t *testing.T) {
+		attempt := queue.attempts[1]
+		assert.Equal(t, attempt.Delivered, true)
+		assert.Equal(t, attempt.ResponseCode, http.StatusNoContent)
+		assert.Equal(t, attempt.Error, "")
+	})
+
+	t.Run("Retried with backoff", func(t *testing.T) {
+		attempt := queue.attempts[2]
+		assert.Equal(t, attempt.Delivered, false)
+		assert.Equal(t, attempt.ResponseCode, http.StatusInternalServerError)
+		assert.StringContains(t, attempt.Error, "500")
+		// This was the third attempt, so the next one is 2 minutes later.
+		assert.Equal(t, attempt.NextAttempt.Sub(attempt.Time), 2*time.Minute)
+	})
+
+	t.Run("Given up", func(t *testing.T) {
+		attempt := queue.attempts[3]
+		assert.Equal(t, attempt.Delivered, false)
+		assert.Equal(t, attempt.NextAttempt.IsZero(), true)
+	})
+
+	t.Run("Bad signature", func(t *testing.T) {
+		attempt := queue.attempts[4]
+		assert.Equal(t, attempt.Delivered, false)
+		assert.Equal(t, attempt.ResponseCode, http.StatusUnauthorized)
+	})
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook was delivered to a loopback address")
+	}))
+	defer receiver.Close()
+
+	queue := &fakeWebhookQueue{
+		deliveries: []models.WebhookDelivery{
+			{ID: 1, URL: receiver.URL, Event: models.EventPing, Payload: []byte(`{}`)},
+		},
+		attempts: map[int]models.WebhookAttempt{},
+	}
+
+	d := newWebhookDispatcher(queue, slog.New(slog.NewTextHandler(io.Discard, nil)), false)
+
+	err := d.deliverDue(context.Background())
+	assert.NilError(t, err)
+
+	attempt := queue.attempts[1]
+	assert.Equal(t, attempt.Delivered, false)
+	assert.Equal(t, strings.Contains(attempt.Error, errPrivateAddress.Error()), true)
+}
+
+func TestSnippetEventQueued(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Alice's webhook subscribes to snippet.created, so creating a snippet
+	// should wake the dispatcher.
+	code, _, _ := ts.do(t, http.MethodPost, "/api/v1/snippets", apiHeaders(mocks.WriteToken), `{"title": "Hello", "content": "World", "expires": 7}`)
+	assert.Equal(t, code, http.StatusCreated)
+
+	select {
+	case <-app.webhookDispatcher.wakeup:
+	default:
+		t.Error("dispatcher was not woken")
+	}
+}
@@ -0,0 +1,389 @@
+// Package cli implements the snippet command-line client. It lives outside of
+// cmd/snippet so that it can be tested against the real web application.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+const defaultServer = "https://localhost:4000"
+
+// The number of days a snippet can be kept for. These must match the values
+// permitted by the server.
+var permittedExpires = []int{1, 7, 365}
+
+const usage = `Usage: snippet <command> [flags] [arguments]
+
+Commands:
+  create -t <title> [-expires 1|7|365] < file   create a snippet from stdin
+  get <id>                                      print a snippet
+  list [-page n] [-page-size n]                 list the latest snippets
+  delete <id>                                   delete one of your snippets
+
+Common flags:
+  -server <url>      server address (default $SNIPPETBOX_SERVER or ` + defaultServer + `)
+  -token <token>     API token (default $SNIPPETBOX_TOKEN or the config file)
+  -config <path>     config file (default <user config dir>/snippetbox/config)
+  -format plain|json output format (default plain)
+  -insecure          skip TLS certificate verification
+
+The config file holds "key = value" lines, with the keys server and token.
+`
+
+// Env holds the process environment which the CLI runs in. Tests can replace
+// any part of it.
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Getenv func(string) string
+	// HTTPClient is used for requests to the server. If nil, a client is
+	// created based on the -insecure flag.
+	HTTPClient *http.Client
+}
+
+// OSEnv returns an Env for the real process environment.
+func OSEnv() Env {
+	return Env{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Getenv: os.Getenv,
+	}
+}
+
+// errUsage is returned for invalid command lines, after the problem has been
+// reported.
+var errUsage = errors.New("usage error")
+
+// The options struct holds the flags which are common to all commands.
+type options struct {
+	server   string
+	token    string
+	config   string
+	format   string
+	insecure bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.server, "server", "", "server address")
+	fs.StringVar(&o.token, "token", "", "API token")
+	fs.StringVar(&o.config, "config", "", "config file")
+	fs.StringVar(&o.format, "format", "plain", "output format (plain|json)")
+	fs.BoolVar(&o.insecure, "insecure", false, "skip TLS certificate verification")
+}
+
+// Run runs the CLI with the given arguments (not including the program name)
+// and returns the process exit code.
+func Run(ctx context.Context, args []string, env Env) int {
+	err := run(ctx, args, env)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(env.Stderr, "snippet: %v\n", err)
+		return 1
+	}
+}
+
+func run(ctx context.Context, args []string, env Env) error {
+	if len(args) == 0 {
+		fmt.Fprint(env.Stderr, usage)
+		return errUsage
+	}
+
+	command, args := args[0], args[1:]
+
+	var opts options
+	fs := flag.NewFlagSet("snippet "+command, flag.ContinueOnError)
+	fs.SetOutput(env.Stderr)
+	fs.Usage = func() { fmt.Fprint(env.Stderr, usage) }
+	opts.register(fs)
+
+	switch command {
+	case "create":
+		title := fs.String("t", "", "snippet title")
+		expires := fs.Int("expires", 365, "days until the snippet expires (1, 7 or 365)")
+		if _, err := parseFlags(fs, args, 0); err != nil {
+			return err
+		}
+		if !slices.Contains(permittedExpires, *expires) {
+			fmt.Fprintf(env.Stderr, "snippet: -expires must be 1, 7 or 365\n")
+			return errUsage
+		}
+
+		client, err := newClient(opts, env)
+		if err != nil {
+			return err
+		}
+		return create(ctx, client, opts, env, *title, *expires)
+
+	case "get", "delete":
+		positional, err := parseFlags(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(positional[0])
+		if err != nil || id < 1 {
+			fmt.Fprintf(env.Stderr, "snippet: invalid snippet ID %q\n", positional[0])
+			return errUsage
+		}
+
+		client, err := newClient(opts, env)
+		if err != nil {
+			return err
+		}
+		if command == "get" {
+			return get(ctx, client, opts, env, id)
+		}
+		return remove(ctx, client, opts, env, id)
+
+	case "list":
+		page := fs.Int("page", 1, "page number")
+		pageSize := fs.Int("page-size", 20, "snippets per page")
+		if _, err := parseFlags(fs, args, 0); err != nil {
+			return err
+		}
+
+		client, err := newClient(opts, env)
+		if err != nil {
+			return err
+		}
+		return list(ctx, client, opts, env, *page, *pageSize)
+
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(env.Stdout, usage)
+		return nil
+
+	default:
+		fmt.Fprintf(env.Stderr, "snippet: unknown command %q\n\n%s", command, usage)
+		return errUsage
+	}
+}
+
+// parseFlags parses the flags in args, allowing them to appear before or after
+// the positional arguments (so that both "get -format json 1" and "get 1
+// -format json" work). It checks that there are exactly n positional
+// arguments and returns them.
+func parseFlags(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+
+	for {
+		// The flag set has already reported the problem.
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != n {
+		fmt.Fprintf(fs.Output(), "%s: expected %d argument(s), got %d\n", fs.Name(), n, len(positional))
+		return nil, errUsage
+	}
+
+	return positional, nil
+}
+
+// newClient creates the API client, taking each setting from the command line
+// flags, then the environment, then the config file.
+func newClient(opts options, env Env) (*Client, error) {
+	if opts.format != "plain" && opts.format != "json" {
+		return nil, fmt.Errorf("unknown format %q", opts.format)
+	}
+
+	config, err := readConfig(opts.config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		BaseURL:    firstNonEmpty(opts.server, env.Getenv("SNIPPETBOX_SERVER"), config["server"], defaultServer),
+		Token:      firstNonEmpty(opts.token, env.Getenv("SNIPPETBOX_TOKEN"), config["token"]),
+		HTTPClient: env.HTTPClient,
+	}
+
+	if client.HTTPClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if opts.insecure {
+			// Useful with the self-signed certificate used in development.
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+
+	return client, nil
+}
+
+// readConfig reads the config file. A missing file is only an error if its
+// path was given explicitly.
+func readConfig(path string) (map[string]string, error) {
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return map[string]string{}, nil
+		}
+		path = filepath.Join(dir, "snippetbox", "config")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	config := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return config, scanner.Err()
+}
+
+func create(ctx context.Context, client *Client, opts options, env Env, title string, expires int) error {
+	content, err := io.ReadAll(env.Stdin)
+	if err != nil {
+		return err
+	}
+
+	snippet, err := client.Create(ctx, title, string(content), expires)
+	if err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		return writeJSON(env.Stdout, snippet)
+	}
+
+	fmt.Fprintln(env.Stdout, client.SnippetURL(snippet.ID))
+	return nil
+}
+
+func get(ctx context.Context, client *Client, opts options, env Env, id int) error {
+	snippet, err := client.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		return writeJSON(env.Stdout, snippet)
+	}
+
+	// Print just the content, so that the output of a single-file snippet can
+	// be redirected to a file and get back exactly what was saved. Snippets
+	// with more than one file are printed in the same format as the server's
+	// .txt view, with a header before each file.
+	if len(snippet.Files) < 2 {
+		_, err = io.WriteString(env.Stdout, snippet.Content)
+		return err
+	}
+
+	for i, f := range snippet.Files {
+		if i > 0 {
+			fmt.Fprintln(env.Stdout)
+		}
+		fmt.Fprintf(env.Stdout, "==> %s <==\n", f.Name)
+		io.WriteString(env.Stdout, f.Content)
+		if !strings.HasSuffix(f.Content, "\n") {
+			fmt.Fprintln(env.Stdout)
+		}
+	}
+
+	return nil
+}
+
+func list(ctx context.Context, client *Client, opts options, env Env, page, pageSize int) error {
+	snippets, metadata, err := client.List(ctx, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		return writeJSON(env.Stdout, struct {
+			Snippets []models.Snippet `json:"snippets"`
+			Metadata Pagination       `json:"metadata"`
+		}{snippets, metadata})
+	}
+
+	tw := tabwriter.NewWriter(env.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tEXPIRES")
+	for _, s := range snippets {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", s.ID, s.Title, s.Expires.UTC().Format("2006-01-02 15:04"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if metadata.LastPage > 1 {
+		fmt.Fprintf(env.Stdout, "\nPage %d of %d (%d snippets)\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	}
+
+	return nil
+}
+
+func remove(ctx context.Context, client *Client, opts options, env Env, id int) error {
+	err := client.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		return writeJSON(env.Stdout, map[string]any{"deleted": id})
+	}
+
+	fmt.Fprintf(env.Stdout, "Deleted snippet %d\n", id)
+	return nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
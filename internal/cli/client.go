@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The Client type is a small client for the snippetbox JSON API.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Pagination holds the pagination metadata returned when listing snippets.
+type Pagination struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// APIError is returned for any non-2xx response from the API.
+type APIError struct {
+	StatusCode int
+	Message    string            `json:"message"`
+	Fields     map[string]string `json:"fields"`
+	RequestID  string            `json:"request_id"`
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%d)", e.Message, e.StatusCode)
+
+	// Sort the field names so that the output is stable.
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n  %s: %s", k, e.Fields[k])
+	}
+
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, "\n  request ID: %s", e.RequestID)
+	}
+
+	return b.String()
+}
+
+// Create creates a new snippet and returns it.
+func (c *Client) Create(ctx context.Context, title, content string, expires int) (models.Snippet, error) {
+	input := map[string]any{"title": title, "content": content, "expires": expires}
+
+	var resp struct {
+		Snippet models.Snippet `json:"snippet"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v1/snippets", input, &resp)
+	return resp.Snippet, err
+}
+
+func (c *Client) Get(ctx context.Context, id int) (models.Snippet, error) {
+	var resp struct {
+		Snippet models.Snippet `json:"snippet"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/snippets/"+strconv.Itoa(id), nil, &resp)
+	return resp.Snippet, err
+}
+
+func (c *Client) List(ctx context.Context, page, pageSize int) ([]models.Snippet, Pagination, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("page_size", strconv.Itoa(pageSize))
+
+	var resp struct {
+		Snippets []models.Snippet `json:"snippets"`
+		Metadata Pagination       `json:"metadata"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v1/snippets?"+query.Encode(), nil, &resp)
+	return resp.Snippets, resp.Metadata, err
+}
+
+func (c *Client) Delete(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/snippets/"+strconv.Itoa(id), nil, nil)
+}
+
+// SnippetURL returns the address of the HTML page for a snippet.
+func (c *Client) SnippetURL(id int) string {
+	return strings.TrimRight(c.BaseURL, "/") + "/snippet/view/" + strconv.Itoa(id)
+}
+
+// do sends a request to the API, encoding input (if not nil) as the JSON body
+// and decoding the JSON response into output (if not nil).
+func (c *Client) do(ctx context.Context, method, path string, input, output any) error {
+	var body io.Reader
+	if input != nil {
+		js, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	rs, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode < 200 || rs.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: rs.StatusCode}
+
+		var errResp struct {
+			Error *APIError `json:"error"`
+		}
+		errResp.Error = apiErr
+
+		err = json.NewDecoder(rs.Body).Decode(&errResp)
+		if err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(rs.StatusCode)
+		}
+
+		return apiErr
+	}
+
+	if output == nil {
+		return nil
+	}
+
+	return json.NewDecoder(rs.Body).Decode(output)
+}
@@ -0,0 +1,162 @@
+// Package mailer sends the emails which the web application needs, such as
+// address verification links. Mail goes through the Mailer interface, which
+// has an SMTP implementation for production and outboxes which keep messages
+// locally for development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errHeaderInjection = errors.New("mailer: header values cannot contain line breaks")
+
+// A Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format returns the message in RFC 5322 format, from the given sender.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errHeaderInjection
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return b.Bytes(), nil
+}
+
+// SMTP sends mail through an SMTP server. The connection is upgraded with
+// STARTTLS if the server supports it, and authentication is only attempted
+// if Username is set.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.Sender, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The envelope sender is just the address, without any display name.
+	sender, err := mail.ParseAddress(m.Sender)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	// smtp.SendMail doesn't take a context, so run it in the background and
+	// stop waiting for it if ctx is cancelled first.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, sender.Address, []string{msg.To}, data)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileOutbox writes each message to a file in Dir instead of sending it, so
+// that the emails sent during local development can be read (and their links
+// followed) without a mail server.
+type FileOutbox struct {
+	Dir    string
+	Sender string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (m *FileOutbox) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+
+	data, err := format(m.Sender, msg, now)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	// Name the files after the time they were sent, with a counter to keep
+	// them unique and in order.
+	m.mu.Lock()
+	m.n++
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405"), m.n)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// MemoryOutbox keeps the messages it is given in memory. It is meant for
+// tests.
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryOutbox) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errHeaderInjection
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemoryOutbox) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestFileOutbox(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileOutbox{Dir: filepath.Join(dir, "outbox"), Sender: "Snippetbox <no-reply@example.com>"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Hello",
+		Body:    "Line one\nLine two\n",
+	})
+	assert.NilError(t, err)
+
+	files, err := os.ReadDir(m.Dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 1)
+	assert.Equal(t, strings.HasSuffix(files[0].Name(), ".eml"), true)
+
+	data, err := os.ReadFile(filepath.Join(m.Dir, files[0].Name()))
+	assert.NilError(t, err)
+	assert.StringContains(t, string(data), "From: Snippetbox <no-reply@example.com>\r\n")
+	assert.StringContains(t, string(data), "To: alice@example.com\r\n")
+	assert.StringContains(t, string(data), "Subject: Hello\r\n")
+	assert.StringContains(t, string(data), "\r\n\r\nLine one\r\nLine two\r\n")
+}
+
+func TestHeaderInjection(t *testing.T) {
+	msg := Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hello"}
+
+	err := (&FileOutbox{Dir: t.TempDir()}).Send(context.Background(), msg)
+	assert.Equal(t, err, errHeaderInjection)
+
+	err = (&MemoryOutbox{}).Send(context.Background(), msg)
+	assert.Equal(t, err, errHeaderInjection)
+}
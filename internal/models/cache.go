@@ -0,0 +1,263 @@
+package models
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheStats holds the hit and miss counters for a CachedSnippetModel.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// Once this many snippets are cached the map is emptied, to stop the cache
+// from growing without bound.
+const maxCachedSnippets = 1000
+
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// CachedSnippetModel wraps another SnippetModelInterface and keeps the results
+// of Get() and Latest() in memory. It implements SnippetModelInterface itself,
+// so handlers don't need to know whether caching is switched on or not.
+type CachedSnippetModel struct {
+	next SnippetModelInterface
+	ttl  time.Duration
+
+	// The group makes sure that concurrent misses for the same key only result
+	// in a single call to the underlying model (i.e. a single database query).
+	group singleflight.Group
+
+	mu         sync.Mutex
+	generation uint64 // incremented on every write, see invalidate()
+	snippets   map[int]cacheEntry[Snippet]
+	latest     *cacheEntry[[]Snippet]
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCachedSnippetModel returns a CachedSnippetModel which caches entries for
+// at most ttl. An entry is never kept beyond the Expires time of the snippet(s)
+// it contains.
+func NewCachedSnippetModel(next SnippetModelInterface, ttl time.Duration) *CachedSnippetModel {
+	return &CachedSnippetModel{
+		next:     next,
+		ttl:      ttl,
+		snippets: make(map[int]cacheEntry[Snippet]),
+	}
+}
+
+// Stats returns a snapshot of the hit and miss counters.
+func (m *CachedSnippetModel) Stats() CacheStats {
+	return CacheStats{
+		Hits:   m.hits.Load(),
+		Misses: m.misses.Load(),
+	}
+}
+
+func (m *CachedSnippetModel) Insert(ctx context.Context, userID int, title string, files []File, expires int, tags []string) (int, error) {
+	id, err := m.next.Insert(ctx, userID, title, files, expires, tags)
+	if err != nil {
+		return 0, err
+	}
+
+	// A new snippet changes the result of Latest(), but no cached Get() result.
+	m.invalidate(-1)
+
+	return id, nil
+}
+
+func (m *CachedSnippetModel) Fork(ctx context.Context, id, userID, expires int) (int, error) {
+	forkID, err := m.next.Fork(ctx, id, userID, expires)
+	if err != nil {
+		return 0, err
+	}
+
+	// Like Insert(), a fork is a new snippet which only changes Latest().
+	m.invalidate(-1)
+
+	return forkID, nil
+}
+
+// Forks is only used on the view page, below the (cached) snippet itself, so
+// it always goes to the underlying model.
+func (m *CachedSnippetModel) Forks(ctx context.Context, id int) ([]Snippet, error) {
+	return m.next.Forks(ctx, id)
+}
+
+// List is used for paging through all snippets, which doesn't benefit much
+// from caching, so it always goes to the underlying model.
+func (m *CachedSnippetModel) List(ctx context.Context, page, pageSize int) ([]Snippet, int, error) {
+	return m.next.List(ctx, page, pageSize)
+}
+
+// LatestByUser and LatestByTag are only used for feeds, which are cheap to
+// serve with conditional requests, so they aren't cached either.
+func (m *CachedSnippetModel) LatestByUser(ctx context.Context, userID int) ([]Snippet, error) {
+	return m.next.LatestByUser(ctx, userID)
+}
+
+func (m *CachedSnippetModel) LatestByTag(ctx context.Context, tag string) ([]Snippet, error) {
+	return m.next.LatestByTag(ctx, tag)
+}
+
+func (m *CachedSnippetModel) Update(ctx context.Context, id int, title string, files []File, expires int) error {
+	// Invalidate even if the update failed, as we can't be sure what state
+	// the database has been left in.
+	defer m.invalidate(id)
+
+	return m.next.Update(ctx, id, title, files, expires)
+}
+
+func (m *CachedSnippetModel) Delete(ctx context.Context, id int) error {
+	defer m.invalidate(id)
+
+	return m.next.Delete(ctx, id)
+}
+
+func (m *CachedSnippetModel) Get(ctx context.Context, id int) (Snippet, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	entry, ok := m.snippets[id]
+	if ok && now.Before(entry.expiresAt) {
+		m.mu.Unlock()
+		m.hits.Add(1)
+		return entry.value, nil
+	}
+	generation := m.generation
+	m.mu.Unlock()
+
+	m.misses.Add(1)
+
+	// The query is shared with other callers, so it mustn't be cancelled just
+	// because the request which happened to start it has gone away.
+	ctx = context.WithoutCancel(ctx)
+
+	v, err, _ := m.group.Do("get:"+strconv.Itoa(id), func() (any, error) {
+		s, err := m.next.Get(ctx, id)
+		if err != nil {
+			return Snippet{}, err
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// Only store the result if nothing was written while we were loading
+		// it, otherwise we could put stale data back into the cache.
+		if generation == m.generation {
+			if len(m.snippets) >= maxCachedSnippets {
+				clear(m.snippets)
+			}
+			m.snippets[id] = cacheEntry[Snippet]{value: s, expiresAt: earliest(now.Add(m.ttl), s.Expires)}
+		}
+
+		return s, nil
+	})
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	return v.(Snippet), nil
+}
+
+func (m *CachedSnippetModel) Latest(ctx context.Context) ([]Snippet, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	if m.latest != nil && now.Before(m.latest.expiresAt) {
+		snippets := m.latest.value
+		m.mu.Unlock()
+		m.hits.Add(1)
+		return snippets, nil
+	}
+	generation := m.generation
+	m.mu.Unlock()
+
+	m.misses.Add(1)
+
+	ctx = context.WithoutCancel(ctx)
+
+	v, err, _ := m.group.Do("latest", func() (any, error) {
+		snippets, err := m.next.Latest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// The list must be refreshed as soon as the first of its snippets
+		// expires, so use the earliest expiry time as the upper bound.
+		expiresAt := now.Add(m.ttl)
+		for _, s := range snippets {
+			expiresAt = earliest(expiresAt, s.Expires)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if generation == m.generation {
+			m.latest = &cacheEntry[[]Snippet]{value: snippets, expiresAt: expiresAt}
+		}
+
+		return snippets, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Snippet), nil
+}
+
+// StarModel wraps a StarModelInterface so that starring or unstarring a
+// snippet drops it from the cache, along with the Latest() result, since both
+// include the snippet's star count.
+func (m *CachedSnippetModel) StarModel(next StarModelInterface) StarModelInterface {
+	return &cachedStarModel{StarModelInterface: next, cache: m}
+}
+
+type cachedStarModel struct {
+	StarModelInterface
+	cache *CachedSnippetModel
+}
+
+func (m *cachedStarModel) Star(ctx context.Context, userID, snippetID int) error {
+	defer m.cache.invalidate(snippetID)
+
+	return m.StarModelInterface.Star(ctx, userID, snippetID)
+}
+
+func (m *cachedStarModel) Unstar(ctx context.Context, userID, snippetID int) error {
+	defer m.cache.invalidate(snippetID)
+
+	return m.StarModelInterface.Unstar(ctx, userID, snippetID)
+}
+
+// earliest returns whichever of the two times comes first, ignoring a zero
+// snippet expiry time.
+func earliest(deadline, expires time.Time) time.Time {
+	if !expires.IsZero() && expires.Before(deadline) {
+		return expires
+	}
+	return deadline
+}
+
+// invalidate drops the cached Latest() result and, if id is positive, the
+// cached Get() result for that snippet.
+func (m *CachedSnippetModel) invalidate(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generation++
+	m.latest = nil
+	if id > 0 {
+		delete(m.snippets, id)
+	}
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+// countingSnippetModel is a minimal SnippetModelInterface which records how
+// many times each method was called. The block channel, if set, holds up calls
+// to Get() and Latest() so that concurrent misses can be simulated.
+type countingSnippetModel struct {
+	gets    atomic.Int64
+	latests atomic.Int64
+	block   chan struct{}
+	expires time.Time
+}
+
+func (m *countingSnippetModel) Insert(ctx context.Context, userID int, title string, files []File, expires int, tags []string) (int, error) {
+	return 2, nil
+}
+
+func (m *countingSnippetModel) LatestByUser(ctx context.Context, userID int) ([]Snippet, error) {
+	return nil, nil
+}
+
+func (m *countingSnippetModel) LatestByTag(ctx context.Context, tag string) ([]Snippet, error) {
+	return nil, nil
+}
+
+func (m *countingSnippetModel) List(ctx context.Context, page, pageSize int) ([]Snippet, int, error) {
+	return nil, 0, nil
+}
+
+func (m *countingSnippetModel) Update(ctx context.Context, id int, title string, files []File, expires int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) Delete(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) Fork(ctx context.Context, id, userID, expires int) (int, error) {
+	return 2, nil
+}
+
+func (m *countingSnippetModel) Forks(ctx context.Context, id int) ([]Snippet, error) {
+	return nil, nil
+}
+
+func (m *countingSnippetModel) Get(ctx context.Context, id int) (Snippet, error) {
+	m.gets.Add(1)
+	if m.block != nil {
+		<-m.block
+	}
+	if id != 1 {
+		return Snippet{}, ErrNoRecord
+	}
+	return Snippet{ID: 1, Title: "An old silent pond", Expires: m.expires}, nil
+}
+
+func (m *countingSnippetModel) Latest(ctx context.Context) ([]Snippet, error) {
+	m.latests.Add(1)
+	if m.block != nil {
+		<-m.block
+	}
+	return []Snippet{{ID: 1, Expires: m.expires}}, nil
+}
+
+func TestCachedSnippetModelGet(t *testing.T) {
+	ctx := context.Background()
+	next := &countingSnippetModel{expires: time.Now().Add(time.Hour)}
+	m := NewCachedSnippetModel(next, time.Minute)
+
+	for range 3 {
+		s, err := m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.ID, 1)
+	}
+
+	assert.Equal(t, next.gets.Load(), int64(1))
+	assert.Equal(t, m.Stats(), CacheStats{Hits: 2, Misses: 1})
+
+	// Missing records are not cached.
+	for range 2 {
+		_, err := m.Get(ctx, 2)
+		assert.Equal(t, err, ErrNoRecord)
+	}
+	assert.Equal(t, next.gets.Load(), int64(3))
+}
+
+func TestCachedSnippetModelExpires(t *testing.T) {
+	ctx := context.Background()
+	// The snippet has already expired, so it must never be served from cache
+	// even though the TTL is much longer.
+	next := &countingSnippetModel{expires: time.Now().Add(-time.Second)}
+	m := NewCachedSnippetModel(next, time.Hour)
+
+	m.Get(ctx, 1)
+	m.Get(ctx, 1)
+	m.Latest(ctx)
+	m.Latest(ctx)
+
+	assert.Equal(t, next.gets.Load(), int64(2))
+	assert.Equal(t, next.latests.Load(), int64(2))
+}
+
+func TestCachedSnippetModelInsertInvalidates(t *testing.T) {
+	ctx := context.Background()
+	next := &countingSnippetModel{expires: time.Now().Add(time.Hour)}
+	m := NewCachedSnippetModel(next, time.Minute)
+
+	m.Latest(ctx)
+	m.Latest(ctx)
+	assert.Equal(t, next.latests.Load(), int64(1))
+
+	_, err := m.Insert(ctx, 1, "title", []File{{Name: "a.txt", Content: "content"}}, 7, nil)
+	assert.NilError(t, err)
+
+	m.Latest(ctx)
+	assert.Equal(t, next.latests.Load(), int64(2))
+}
+
+func TestCachedSnippetModelCoalesces(t *testing.T) {
+	ctx := context.Background()
+	next := &countingSnippetModel{expires: time.Now().Add(time.Hour), block: make(chan struct{})}
+	m := NewCachedSnippetModel(next, time.Minute)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Latest(ctx)
+		}()
+	}
+
+	// Give the goroutines a chance to pile up behind the first call before
+	// letting it complete.
+	time.Sleep(50 * time.Millisecond)
+	close(next.block)
+	wg.Wait()
+
+	assert.Equal(t, next.latests.Load(), int64(1))
+}
+
+func TestCachedSnippetModelDeleteInvalidates(t *testing.T) {
+	ctx := context.Background()
+	next := &countingSnippetModel{expires: time.Now().Add(time.Hour)}
+	m := NewCachedSnippetModel(next, time.Minute)
+
+	m.Get(ctx, 1)
+	m.Get(ctx, 1)
+	assert.Equal(t, next.gets.Load(), int64(1))
+
+	err := m.Delete(ctx, 1)
+	assert.NilError(t, err)
+
+	m.Get(ctx, 1)
+	assert.Equal(t, next.gets.Load(), int64(2))
+}
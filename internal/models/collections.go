@@ -0,0 +1,340 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type CollectionModelInterface interface {
+	Insert(ctx context.Context, userID int, name, description string) (int, error)
+	Get(ctx context.Context, id int) (Collection, error)
+	ForUser(ctx context.Context, userID int) ([]Collection, error)
+	Delete(ctx context.Context, id, userID int) error
+	AddSnippet(ctx context.Context, id, userID, snippetID int) error
+	RemoveSnippet(ctx context.Context, id, userID, snippetID int) error
+	MoveSnippet(ctx context.Context, id, userID, snippetID int, up bool) error
+}
+
+// A Collection is a named, ordered list of snippets belonging to a user.
+// Snippets can be viewed by anyone, so collections can be too. Expired
+// snippets stay in the collection but aren't shown.
+type Collection struct {
+	ID          int
+	UserID      int
+	UserName    string // the owner's name; only loaded by Get()
+	Name        string
+	Description string
+	Created     time.Time
+	Snippets    []Snippet // with their files, in order; only loaded by Get()
+	SnippetIDs  []int     // in order; only loaded by ForUser()
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID int, name, description string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `INSERT INTO collections (user_id, name, description, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, name, description)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns a collection along with its unexpired snippets.
+func (m *CollectionModel) Get(ctx context.Context, id int) (_ Collection, err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT c.id, c.user_id, u.name, c.name, c.description, c.created
+	FROM collections c INNER JOIN users u ON u.id = c.user_id WHERE c.id = ?`
+
+	var c Collection
+
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&c.ID, &c.UserID, &c.UserName, &c.Name, &c.Description, &c.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Collection{}, ErrNoRecord
+		}
+		return Collection{}, err
+	}
+
+	stmt = `SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires, s.stars
+	FROM snippets s INNER JOIN collection_snippets cs ON cs.snippet_id = s.id
+	WHERE s.expires > UTC_TIMESTAMP() AND cs.collection_id = ? ORDER BY cs.position`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return Collection{}, err
+	}
+	defer rows.Close()
+
+	c.Snippets, err = scanSnippets(rows)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	// Collections are short, so the files are loaded a snippet at a time.
+	snippets := SnippetModel{DB: m.DB}
+	for i := range c.Snippets {
+		err = snippets.loadFiles(ctx, &c.Snippets[i])
+		if err != nil {
+			return Collection{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// ForUser returns the user's collections, in order of name, with the IDs of
+// their unexpired snippets.
+func (m *CollectionModel) ForUser(ctx context.Context, userID int) (_ []Collection, err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.ForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, name, description, created FROM collections WHERE user_id = ? ORDER BY name, id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	index := map[int]int{}
+
+	for rows.Next() {
+		var c Collection
+
+		err = rows.Scan(&c.ID, &c.UserID, &c.Name, &c.Description, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		index[c.ID] = len(collections)
+		collections = append(collections, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmt = `SELECT cs.collection_id, cs.snippet_id
+	FROM collection_snippets cs
+	INNER JOIN collections c ON c.id = cs.collection_id
+	INNER JOIN snippets s ON s.id = cs.snippet_id
+	WHERE c.user_id = ? AND s.expires > UTC_TIMESTAMP() ORDER BY cs.collection_id, cs.position`
+
+	rows, err = m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var collectionID, snippetID int
+
+		err = rows.Scan(&collectionID, &snippetID)
+		if err != nil {
+			return nil, err
+		}
+
+		i := index[collectionID]
+		collections[i].SnippetIDs = append(collections[i].SnippetIDs, snippetID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Delete removes one of the user's collections. The snippets in it are left
+// alone.
+func (m *CollectionModel) Delete(ctx context.Context, id, userID int) (err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM collections WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// AddSnippet adds an unexpired snippet to the end of one of the user's
+// collections. Adding a snippet which is already in the collection does
+// nothing.
+func (m *CollectionModel) AddSnippet(ctx context.Context, id, userID, snippetID int) (err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.AddSnippet")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := lockCollection(ctx, m.DB, id, userID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+
+	stmt := `SELECT COALESCE(MAX(position), 0) + 1 FROM collection_snippets WHERE collection_id = ?`
+
+	err = tx.QueryRowContext(ctx, stmt, id).Scan(&position)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO collection_snippets (collection_id, snippet_id, position)
+	SELECT ?, id, ? FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?
+	ON DUPLICATE KEY UPDATE position = position`
+
+	result, err := tx.ExecContext(ctx, stmt, id, position, snippetID)
+	if err != nil {
+		return err
+	}
+
+	// Nothing was inserted either because the snippet was already there, or
+	// because it doesn't exist.
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?)`
+
+		err = tx.QueryRowContext(ctx, stmt, snippetID).Scan(&exists)
+		if err == nil && !exists {
+			err = ErrNoRecord
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveSnippet removes a snippet from one of the user's collections, if it
+// is there.
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, id, userID, snippetID int) (err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.RemoveSnippet")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := lockCollection(ctx, m.DB, id, userID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, id, snippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveSnippet swaps a snippet in one of the user's collections with the one
+// before it (if up is true) or after it. Moving the first snippet up, or the
+// last one down, does nothing.
+func (m *CollectionModel) MoveSnippet(ctx context.Context, id, userID, snippetID int, up bool) (err error) {
+	ctx, span := tracer.Start(ctx, "CollectionModel.MoveSnippet")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := lockCollection(ctx, m.DB, id, userID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+
+	stmt := `SELECT position FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`
+
+	err = tx.QueryRowContext(ctx, stmt, id, snippetID).Scan(&position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	// Positions have gaps where snippets were removed, so find the nearest
+	// one in the direction of the move. Expired snippets aren't shown, so
+	// they are skipped over.
+	stmt = `SELECT cs.snippet_id, cs.position FROM collection_snippets cs
+	INNER JOIN snippets s ON s.id = cs.snippet_id
+	WHERE cs.collection_id = ? AND s.expires > UTC_TIMESTAMP() AND cs.position > ?
+	ORDER BY cs.position LIMIT 1`
+	if up {
+		stmt = `SELECT cs.snippet_id, cs.position FROM collection_snippets cs
+		INNER JOIN snippets s ON s.id = cs.snippet_id
+		WHERE cs.collection_id = ? AND s.expires > UTC_TIMESTAMP() AND cs.position < ?
+		ORDER BY cs.position DESC LIMIT 1`
+	}
+
+	var otherID, otherPosition int
+
+	err = tx.QueryRowContext(ctx, stmt, id, position).Scan(&otherID, &otherPosition)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, otherPosition, id, snippetID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, position, id, otherID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockCollection starts a transaction and locks one of the user's
+// collections, so that changes to the order of its snippets don't interleave.
+// It returns ErrNoRecord if the user doesn't have a collection with the ID.
+func lockCollection(ctx context.Context, db *sql.DB, id, userID int) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+
+	stmt := `SELECT true FROM collections WHERE id = ? AND user_id = ? FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, stmt, id, userID).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return tx, nil
+}
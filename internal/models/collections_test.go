@@ -0,0 +1,76 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestCollectionModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := CollectionModel{db}
+	snippets := SnippetModel{db}
+
+	second, err := snippets.Insert(ctx, 1, "Over the wintry forest", []File{{Name: "winter.txt", Content: "Over the wintry forest..."}}, 7, nil)
+	assert.NilError(t, err)
+
+	id, err := m.Insert(ctx, 1, "Haiku", "Short poems")
+	assert.NilError(t, err)
+
+	// Adding a snippet twice only adds it once.
+	for _, snippetID := range []int{1, second, 1} {
+		err = m.AddSnippet(ctx, id, 1, snippetID)
+		assert.NilError(t, err)
+	}
+
+	err = m.AddSnippet(ctx, id, 1, 99)
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Only the owner can change a collection.
+	err = m.AddSnippet(ctx, id, 2, 1)
+	assert.Equal(t, err, ErrNoRecord)
+
+	c, err := m.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, c.Name, "Haiku")
+	assert.Equal(t, c.UserName, "Alice Jones")
+	assert.Equal(t, len(c.Snippets), 2)
+	assert.Equal(t, c.Snippets[0].ID, 1)
+	assert.Equal(t, c.Snippets[0].Files[0].Content, "An old silent pond...")
+
+	// Moving the second snippet up swaps it with the first, and moving it up
+	// again does nothing.
+	for range 2 {
+		err = m.MoveSnippet(ctx, id, 1, second, true)
+		assert.NilError(t, err)
+	}
+
+	collections, err := m.ForUser(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(collections), 1)
+	assert.Equal(t, len(collections[0].SnippetIDs), 2)
+	assert.Equal(t, collections[0].SnippetIDs[0], second)
+	assert.Equal(t, collections[0].SnippetIDs[1], 1)
+
+	err = m.RemoveSnippet(ctx, id, 1, second)
+	assert.NilError(t, err)
+
+	c, err = m.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(c.Snippets), 1)
+
+	err = m.Delete(ctx, id, 2)
+	assert.Equal(t, err, ErrNoRecord)
+
+	err = m.Delete(ctx, id, 1)
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, id)
+	assert.Equal(t, err, ErrNoRecord)
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type CommentModelInterface interface {
+	Insert(ctx context.Context, snippetID, userID, parentID, file, line int, body string) (int, error)
+	Get(ctx context.Context, id int) (Comment, error)
+	Threads(ctx context.Context, snippetID, page, pageSize int) ([]Comment, int, error)
+	LineThreads(ctx context.Context, snippetID int) ([]Comment, error)
+	Update(ctx context.Context, id, userID int, body string) error
+	Delete(ctx context.Context, id, userID int) error
+}
+
+// A Comment is either a general comment on a snippet, or a comment on one
+// line of one of its files. Comments are threaded one level deep: a reply
+// belongs to the thread of the comment it replies to, and is anchored to the
+// same line.
+type Comment struct {
+	ID        int       `json:"id"`
+	SnippetID int       `json:"snippet_id"`
+	UserID    int       `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	ParentID  int       `json:"parent_id,omitempty"` // the first comment in the thread, or 0 if this is it
+	File      int       `json:"file"`                // position of the file commented on, counting from 0
+	Line      int       `json:"line,omitempty"`      // line commented on, counting from 1, or 0 for a general comment
+	Body      string    `json:"body"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"` // zero if the comment has never been edited
+	Replies   []Comment `json:"-"`       // only loaded by Threads() and LineThreads()
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// commentColumns are the columns read by scanComments, which need the
+// comments table to be aliased as c and joined to users as u.
+const commentColumns = `c.id, c.snippet_id, c.user_id, u.name, c.parent_id, c.file, c.line, c.body, c.created, c.updated`
+
+// Insert adds a comment to an unexpired snippet. Replies (with a non-zero
+// parentID) are added to the thread of the comment they reply to, and take
+// its file and line rather than the ones given.
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID, file, line int, body string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var fileCol, lineCol sql.NullInt64
+	if line > 0 {
+		fileCol, lineCol = sql.NullInt64{Int64: int64(file), Valid: true}, sql.NullInt64{Int64: int64(line), Valid: true}
+	}
+
+	if parentID != 0 {
+		stmt := `SELECT COALESCE(parent_id, id), file, line FROM comments WHERE snippet_id = ? AND id = ?`
+
+		err = tx.QueryRowContext(ctx, stmt, snippetID, parentID).Scan(&parentID, &fileCol, &lineCol)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, ErrNoRecord
+			}
+			return 0, err
+		}
+	}
+
+	stmt := `INSERT INTO comments (snippet_id, user_id, parent_id, file, line, body, created)
+	SELECT id, ?, ?, ?, ?, ?, UTC_TIMESTAMP() FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+
+	result, err := tx.ExecContext(ctx, stmt, userID, nullInt(parentID), fileCol, lineCol, body, snippetID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkRowsAffected(result)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id int) (_ Comment, err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT ` + commentColumns + ` FROM comments c INNER JOIN users u ON u.id = c.user_id
+	WHERE c.id = ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return Comment{}, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, ErrNoRecord
+	}
+
+	return comments[0], nil
+}
+
+// Threads returns a page of the general (not line) comment threads on a
+// snippet, newest first, with their replies oldest first. It also returns the
+// total number of general threads. Pages are numbered from 1.
+func (m *CommentModel) Threads(ctx context.Context, snippetID, page, pageSize int) (_ []Comment, total int, err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.Threads")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT COUNT(*) FROM comments WHERE snippet_id = ? AND parent_id IS NULL AND line IS NULL`
+
+	err = m.DB.QueryRowContext(ctx, stmt, snippetID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt = `SELECT ` + commentColumns + ` FROM comments c INNER JOIN users u ON u.id = c.user_id
+	WHERE c.snippet_id = ? AND c.parent_id IS NULL AND c.line IS NULL
+	ORDER BY c.id DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	threads, err := scanComments(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = m.loadReplies(ctx, threads)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return threads, total, nil
+}
+
+// LineThreads returns all the line comment threads on a snippet, in the order
+// of the lines they are on, with their replies oldest first.
+func (m *CommentModel) LineThreads(ctx context.Context, snippetID int) (_ []Comment, err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.LineThreads")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT ` + commentColumns + ` FROM comments c INNER JOIN users u ON u.id = c.user_id
+	WHERE c.snippet_id = ? AND c.parent_id IS NULL AND c.line IS NOT NULL
+	ORDER BY c.file, c.line, c.id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.loadReplies(ctx, threads)
+	if err != nil {
+		return nil, err
+	}
+
+	return threads, nil
+}
+
+// loadReplies fills in the replies to each of the threads.
+func (m *CommentModel) loadReplies(ctx context.Context, threads []Comment) error {
+	if len(threads) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(threads))
+	index := make(map[int]int, len(threads))
+	for i, c := range threads {
+		ids[i] = c.ID
+		index[c.ID] = i
+	}
+
+	stmt := `SELECT ` + commentColumns + ` FROM comments c INNER JOIN users u ON u.id = c.user_id
+	WHERE c.parent_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `) ORDER BY c.id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	replies, err := scanComments(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		i := index[reply.ParentID]
+		threads[i].Replies = append(threads[i].Replies, reply)
+	}
+
+	return nil
+}
+
+// Update replaces the body of one of the user's comments.
+func (m *CommentModel) Update(ctx context.Context, id, userID int, body string) (err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, body, id, userID)
+	if err != nil {
+		return err
+	}
+
+	// As with snippets, an update which doesn't change anything (such as
+	// saving the same body twice in a second) affects zero rows, so check
+	// whether the comment exists before reporting that it doesn't.
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM comments WHERE id = ? AND user_id = ?)`
+
+		err = m.DB.QueryRowContext(ctx, stmt, id, userID).Scan(&exists)
+		if err == nil && !exists {
+			err = ErrNoRecord
+		}
+	}
+
+	return err
+}
+
+// Delete removes one of the user's comments. Deleting the first comment in a
+// thread deletes its replies too.
+func (m *CommentModel) Delete(ctx context.Context, id, userID int) (err error) {
+	ctx, span := tracer.Start(ctx, "CommentModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM comments WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// scanComments reads all the rows of a query which selects commentColumns.
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	var comments []Comment
+	for rows.Next() {
+		var (
+			c                    Comment
+			parentID, file, line sql.NullInt64
+			updated              sql.NullTime
+		)
+		err := rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &parentID, &file, &line, &c.Body, &c.Created, &updated)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentID = int(parentID.Int64)
+		c.File = int(file.Int64)
+		c.Line = int(line.Int64)
+		c.Updated = updated.Time
+
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestCommentModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := CommentModel{newTestDB(t)}
+
+	general, err := m.Insert(ctx, 1, 1, 0, 0, 0, "Is this finished?")
+	assert.NilError(t, err)
+
+	line, err := m.Insert(ctx, 1, 1, 0, 0, 1, "Typo here")
+	assert.NilError(t, err)
+
+	// A reply to a reply joins the thread of the first comment, and is
+	// anchored to the same line whatever it was given.
+	reply, err := m.Insert(ctx, 1, 1, line, 0, 0, "Fixed")
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 1, 1, reply, 3, 7, "Thanks")
+	assert.NilError(t, err)
+
+	_, err = m.Insert(ctx, 1, 1, 99, 0, 0, "Hello")
+	assert.Equal(t, err, ErrNoRecord)
+	_, err = m.Insert(ctx, 2, 1, 0, 0, 0, "Hello")
+	assert.Equal(t, err, ErrNoRecord)
+
+	c, err := m.Get(ctx, general)
+	assert.NilError(t, err)
+	assert.Equal(t, c.UserName, "Alice Jones")
+	assert.Equal(t, c.Line, 0)
+	assert.Equal(t, c.Updated.IsZero(), true)
+
+	threads, total, err := m.Threads(ctx, 1, 1, 20)
+	assert.NilError(t, err)
+	assert.Equal(t, total, 1)
+	assert.Equal(t, len(threads), 1)
+	assert.Equal(t, threads[0].ID, general)
+	assert.Equal(t, len(threads[0].Replies), 0)
+
+	lineThreads, err := m.LineThreads(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(lineThreads), 1)
+	assert.Equal(t, lineThreads[0].Line, 1)
+	assert.Equal(t, len(lineThreads[0].Replies), 2)
+	assert.Equal(t, lineThreads[0].Replies[1].ParentID, line)
+	assert.Equal(t, lineThreads[0].Replies[1].Line, 1)
+
+	// Only the author can edit or delete a comment.
+	err = m.Update(ctx, general, 2, "Is this done?")
+	assert.Equal(t, err, ErrNoRecord)
+	for range 2 {
+		err = m.Update(ctx, general, 1, "Is this done?")
+		assert.NilError(t, err)
+	}
+
+	c, err = m.Get(ctx, general)
+	assert.NilError(t, err)
+	assert.Equal(t, c.Body, "Is this done?")
+	assert.Equal(t, c.Updated.IsZero(), false)
+
+	err = m.Delete(ctx, line, 2)
+	assert.Equal(t, err, ErrNoRecord)
+	err = m.Delete(ctx, line, 1)
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, reply)
+	assert.Equal(t, err, ErrNoRecord)
+}
@@ -10,4 +10,10 @@ var (
 
 	// Tries to signup with an email address that is alread in use
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// Asks for something (such as a password reset email) too often.
+	ErrTooManyRequests = errors.New("models: too many requests")
+
+	// Tries to enable two-factor authentication when it is already enabled.
+	ErrTwoFactorEnabled = errors.New("models: two-factor authentication already enabled")
 )
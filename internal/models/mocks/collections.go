@@ -0,0 +1,84 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The mock user with ID 1 has two collections: one containing the mock
+// snippet, and an empty one. Collection 3 belongs to someone else.
+var mockCollections = []models.Collection{
+	{ID: 1, UserID: 1, UserName: "Alice", Name: "Haiku", Description: "Short poems", Created: time.Now()},
+	{ID: 2, UserID: 1, UserName: "Alice", Name: "Runbooks", Created: time.Now()},
+	{ID: 3, UserID: 2, UserName: "Bob", Name: "Bob's snippets", Created: time.Now()},
+}
+
+type CollectionModel struct{}
+
+func (m *CollectionModel) Insert(ctx context.Context, userID int, name, description string) (int, error) {
+	return 4, nil
+}
+
+func (m *CollectionModel) Get(ctx context.Context, id int) (models.Collection, error) {
+	for _, c := range mockCollections {
+		if c.ID == id {
+			if c.ID == 1 {
+				c.Snippets = []models.Snippet{mockSnippet}
+			}
+			return c, nil
+		}
+	}
+
+	return models.Collection{}, models.ErrNoRecord
+}
+
+func (m *CollectionModel) ForUser(ctx context.Context, userID int) ([]models.Collection, error) {
+	var collections []models.Collection
+	for _, c := range mockCollections {
+		if c.UserID == userID {
+			if c.ID == 1 {
+				c.SnippetIDs = []int{mockSnippet.ID}
+			}
+			collections = append(collections, c)
+		}
+	}
+
+	return collections, nil
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, id, userID int) error {
+	return m.own(id, userID)
+}
+
+func (m *CollectionModel) AddSnippet(ctx context.Context, id, userID, snippetID int) error {
+	if snippetID != mockSnippet.ID {
+		return models.ErrNoRecord
+	}
+
+	return m.own(id, userID)
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, id, userID, snippetID int) error {
+	return m.own(id, userID)
+}
+
+func (m *CollectionModel) MoveSnippet(ctx context.Context, id, userID, snippetID int, up bool) error {
+	if id != 1 || snippetID != mockSnippet.ID {
+		return models.ErrNoRecord
+	}
+
+	return m.own(id, userID)
+}
+
+// own returns ErrNoRecord unless the collection belongs to the user.
+func (m *CollectionModel) own(id, userID int) error {
+	for _, c := range mockCollections {
+		if c.ID == id && c.UserID == userID {
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
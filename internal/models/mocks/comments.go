@@ -0,0 +1,132 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The mock snippet has a general comment by the mock user with a reply from
+// someone else, and a comment on its first line from someone else.
+var (
+	mockComment = models.Comment{
+		ID:        1,
+		SnippetID: 1,
+		UserID:    1,
+		UserName:  "Alice",
+		Body:      "Is this **finished**?",
+		Created:   time.Now(),
+	}
+	mockReply = models.Comment{
+		ID:        2,
+		SnippetID: 1,
+		UserID:    2,
+		UserName:  "Bob",
+		ParentID:  1,
+		Body:      "Not yet.",
+		Created:   time.Now(),
+	}
+	mockLineComment = models.Comment{
+		ID:        3,
+		SnippetID: 1,
+		UserID:    2,
+		UserName:  "Bob",
+		Line:      1,
+		Body:      "Consider `a frog` here.",
+		Created:   time.Now(),
+	}
+)
+
+// CommentModel remembers the last comment inserted, which Get() returns as
+// the comment with ID 4.
+type CommentModel struct {
+	mu       sync.Mutex
+	inserted models.Comment
+}
+
+// Insert returns 4 as the ID of the new comment, unless parentID is given and
+// isn't the ID of one of the mock comments.
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID, file, line int, body string) (int, error) {
+	if snippetID != mockSnippet.ID {
+		return 0, models.ErrNoRecord
+	}
+
+	switch parentID {
+	case 0, mockComment.ID, mockReply.ID, mockLineComment.ID:
+	default:
+		return 0, models.ErrNoRecord
+	}
+
+	c := models.Comment{
+		ID:        4,
+		SnippetID: snippetID,
+		UserID:    userID,
+		ParentID:  parentID,
+		File:      file,
+		Line:      line,
+		Body:      body,
+		Created:   time.Now(),
+	}
+	for _, u := range mockUsers {
+		if u.ID == userID {
+			c.UserName = u.Name
+		}
+	}
+
+	m.mu.Lock()
+	m.inserted = c
+	m.mu.Unlock()
+
+	return c.ID, nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id int) (models.Comment, error) {
+	m.mu.Lock()
+	inserted := m.inserted
+	m.mu.Unlock()
+
+	for _, c := range []models.Comment{mockComment, mockReply, mockLineComment, inserted} {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+
+	return models.Comment{}, models.ErrNoRecord
+}
+
+func (m *CommentModel) Threads(ctx context.Context, snippetID, page, pageSize int) ([]models.Comment, int, error) {
+	if snippetID != mockSnippet.ID {
+		return nil, 0, nil
+	}
+	if page > 1 {
+		return nil, 1, nil
+	}
+
+	thread := mockComment
+	thread.Replies = []models.Comment{mockReply}
+
+	return []models.Comment{thread}, 1, nil
+}
+
+func (m *CommentModel) LineThreads(ctx context.Context, snippetID int) ([]models.Comment, error) {
+	if snippetID != mockSnippet.ID {
+		return nil, nil
+	}
+
+	return []models.Comment{mockLineComment}, nil
+}
+
+func (m *CommentModel) Update(ctx context.Context, id, userID int, body string) error {
+	c, err := m.Get(ctx, id)
+	if err != nil || c.UserID != userID {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *CommentModel) Delete(ctx context.Context, id, userID int) error {
+	return m.Update(ctx, id, userID, "")
+}
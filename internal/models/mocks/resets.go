@@ -0,0 +1,34 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The only valid mock reset token is "valid-reset-token", which is for the
+// mock user with ID 1.
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) Insert(ctx context.Context, email string, ttl, interval time.Duration) (models.User, string, error) {
+	for _, u := range mockUsers {
+		if u.Email == email {
+			return u, "valid-reset-token", nil
+		}
+	}
+
+	return models.User{}, "", models.ErrNoRecord
+}
+
+func (m *PasswordResetModel) Valid(ctx context.Context, plaintext string) (bool, error) {
+	return plaintext == "valid-reset-token", nil
+}
+
+func (m *PasswordResetModel) Reset(ctx context.Context, plaintext, newPassword string) (int, error) {
+	if plaintext != "valid-reset-token" {
+		return 0, models.ErrNoRecord
+	}
+
+	return 1, nil
+}
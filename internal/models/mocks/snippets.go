@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"time"
 
 	"snippetbox.dkimhw.com/internal/models"
@@ -8,19 +9,39 @@ import (
 
 var mockSnippet = models.Snippet{
 	ID:      1,
+	UserID:  1,
 	Title:   "An old silent pond",
 	Content: "An old silent pond...",
 	Created: time.Now(),
 	Expires: time.Now(),
+	Stars:   1,
+	Views:   3,
+	Tags:    []string{"haiku"},
+	Files: []models.File{
+		{Name: "pond.txt", Language: "plaintext", Content: "An old silent pond..."},
+	},
+}
+
+// mockFork is a fork of the mock snippet. It is only returned by Forks().
+var mockFork = models.Snippet{
+	ID:       2,
+	UserID:   2,
+	ParentID: 1,
+	Title:    "An old silent pond (remix)",
+	Content:  "An old silent pond...",
+	Created:  time.Now(),
+	Expires:  time.Now(),
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	return 2, nil
+// Insert returns the ID of the mock snippet, so that handlers which fetch the
+// snippet again after creating it get a record back.
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, files []models.File, expires int, tags []string) (int, error) {
+	return mockSnippet.ID, nil
 }
 
-func (m *SnippetModel) Get(id int) (models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
@@ -29,6 +50,66 @@ func (m *SnippetModel) Get(id int) (models.Snippet, error) {
 	}
 }
 
-func (m *SnippetModel) Latest() ([]models.Snippet, error) {
+func (m *SnippetModel) Latest(ctx context.Context) ([]models.Snippet, error) {
 	return []models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) LatestByUser(ctx context.Context, userID int) ([]models.Snippet, error) {
+	if userID == mockSnippet.UserID {
+		return []models.Snippet{mockSnippet}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *SnippetModel) LatestByTag(ctx context.Context, tag string) ([]models.Snippet, error) {
+	if tag == "haiku" {
+		return []models.Snippet{mockSnippet}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *SnippetModel) List(ctx context.Context, page, pageSize int) ([]models.Snippet, int, error) {
+	if page > 1 {
+		return nil, 1, nil
+	}
+
+	return []models.Snippet{mockSnippet}, 1, nil
+}
+
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, files []models.File, expires int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+// Fork returns the ID of the mock snippet, like Insert().
+func (m *SnippetModel) Fork(ctx context.Context, id, userID, expires int) (int, error) {
+	switch id {
+	case 1:
+		return mockSnippet.ID, nil
+	default:
+		return 0, models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Forks(ctx context.Context, id int) ([]models.Snippet, error) {
+	if id == mockSnippet.ID {
+		return []models.Snippet{mockFork}, nil
+	}
+
+	return nil, nil
+}
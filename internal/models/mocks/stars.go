@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"context"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// StarModel behaves as if the mock user with ID 1 has starred the mock
+// snippet, and nobody else has starred anything.
+type StarModel struct{}
+
+func (m *StarModel) Star(ctx context.Context, userID, snippetID int) error {
+	switch snippetID {
+	case mockSnippet.ID:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *StarModel) Unstar(ctx context.Context, userID, snippetID int) error {
+	return nil
+}
+
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID int) (bool, error) {
+	return userID == 1 && snippetID == mockSnippet.ID, nil
+}
+
+func (m *StarModel) ByUser(ctx context.Context, userID int) ([]models.Snippet, error) {
+	if userID == 1 {
+		return []models.Snippet{mockSnippet}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// The plain-text values of the mock tokens, one for each scope. They all
+// belong to the mock user with ID 1.
+const (
+	ReadToken  = "sbx_READTOKEN"
+	WriteToken = "sbx_WRITETOKEN"
+	AdminToken = "sbx_ADMINTOKEN"
+)
+
+var mockTokens = map[string]models.Token{
+	ReadToken:  {ID: 1, UserID: 1, Name: "read", Scope: models.ScopeRead},
+	WriteToken: {ID: 2, UserID: 1, Name: "write", Scope: models.ScopeWrite},
+	AdminToken: {ID: 3, UserID: 1, Name: "admin", Scope: models.ScopeAdmin},
+}
+
+type TokenModel struct{}
+
+func (m *TokenModel) Insert(ctx context.Context, userID int, name, scope string, ttl time.Duration) (models.Token, error) {
+	now := time.Now()
+
+	return models.Token{
+		ID:        4,
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Created:   now,
+		Expires:   now.Add(ttl),
+		Plaintext: "sbx_NEWTOKEN",
+	}, nil
+}
+
+func (m *TokenModel) Authenticate(ctx context.Context, plaintext string) (models.Token, error) {
+	t, ok := mockTokens[plaintext]
+	if !ok {
+		return models.Token{}, models.ErrInvalidCredentials
+	}
+
+	return t, nil
+}
+
+func (m *TokenModel) GetForUser(ctx context.Context, userID int) ([]models.Token, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+
+	return []models.Token{mockTokens[AdminToken], mockTokens[WriteToken], mockTokens[ReadToken]}, nil
+}
+
+func (m *TokenModel) Delete(ctx context.Context, userID, id int) error {
+	for _, t := range mockTokens {
+		if t.ID == id && t.UserID == userID {
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
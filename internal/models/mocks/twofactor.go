@@ -0,0 +1,103 @@
+package mocks
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// TOTPSecret is the TOTP secret of the mock user with ID 5, and
+// RecoveryCode is their only recovery code.
+const (
+	TOTPSecret   = "JBSWY3DPEHPK3PXP"
+	RecoveryCode = "abcd-efgh"
+)
+
+// The recovery codes returned when two-factor authentication is enabled, or
+// the codes are regenerated.
+var newRecoveryCodes = []string{"ijkl-mnop", "qrst-uvwx"}
+
+type TwoFactorModel struct {
+	mu           sync.Mutex
+	lastStep     int64
+	usedRecovery bool
+}
+
+func (m *TwoFactorModel) Secret(ctx context.Context, userID int) (string, error) {
+	if userID != 5 {
+		return "", models.ErrNoRecord
+	}
+
+	return TOTPSecret, nil
+}
+
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, secret string) ([]string, error) {
+	switch userID {
+	case 1, 3:
+		return newRecoveryCodes, nil
+	case 5:
+		return nil, models.ErrTwoFactorEnabled
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int, password string) error {
+	if password != "pa$$word" {
+		return models.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func (m *TwoFactorModel) RegenerateRecoveryCodes(ctx context.Context, userID int, password string) ([]string, error) {
+	if password != "pa$$word" {
+		return nil, models.ErrInvalidCredentials
+	}
+	if userID != 5 {
+		return nil, models.ErrNoRecord
+	}
+
+	return newRecoveryCodes, nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if userID != 5 || m.usedRecovery {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+// UseStep returns true if step is later than the last one used.
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if userID != 5 || step <= m.lastStep {
+		return false, nil
+	}
+
+	m.lastStep = step
+	return true, nil
+}
+
+// UseRecoveryCode returns true the first time it is called with
+// RecoveryCode, ignoring case and dashes.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	if userID != 5 || m.usedRecovery || code != strings.ReplaceAll(RecoveryCode, "-", "") {
+		return false, nil
+	}
+
+	m.usedRecovery = true
+	return true, nil
+}
@@ -1,55 +1,69 @@
 package mocks
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"snippetbox.dkimhw.com/internal/models"
 )
 
-type UserModel struct{}
+// The mock users are Alice (ID 1), whose email address has been verified,
+// Carol (ID 3), whose address hasn't, and Dave (ID 5), who has enabled
+// two-factor authentication. They all have the password "pa$$word".
+var mockUsers = []models.User{
+	{ID: 1, Name: "Alice", Email: "alice@example.com", EmailVerified: true},
+	{ID: 3, Name: "Carol", Email: "carol@example.com"},
+	{ID: 5, Name: "Dave", Email: "dave@example.com", EmailVerified: true, TwoFactorEnabled: true},
+}
+
+type UserModel struct {
+	mu       sync.Mutex
+	reserved map[int]bool // users who have been sent a verification email
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert returns 4 as the ID of the new user.
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (int, error) {
 	switch email {
 	case "dupe@example.com":
-		return models.ErrDuplicateEmail
+		return 0, models.ErrDuplicateEmail
 	default:
-		return nil
+		return 4, nil
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "pa$$word" {
-		return 1, nil
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	for _, u := range mockUsers {
+		if email == u.Email && password == "pa$$word" {
+			return u.ID, nil
+		}
 	}
 
 	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
-	switch id {
-	case 1:
-		return true, nil
-	default:
-		return false, nil
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	for _, u := range mockUsers {
+		if u.ID == id {
+			return true, nil
+		}
 	}
+
+	return false, nil
 }
 
-func (m *UserModel) Get(id int) (models.User, error) {
-	if id == 1 {
-		u := models.User{
-			ID:      1,
-			Name:    "Alice",
-			Email:   "alice@example.com",
-			Created: time.Now(),
+func (m *UserModel) Get(ctx context.Context, id int) (models.User, error) {
+	for _, u := range mockUsers {
+		if u.ID == id {
+			u.Created = time.Now()
+			return u, nil
 		}
-
-		return u, nil
 	}
 
 	return models.User{}, models.ErrNoRecord
 }
 
-func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error {
 	if id == 1 {
 		if currentPassword != "pa$$word" {
 			return models.ErrInvalidCredentials
@@ -60,3 +74,31 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 
 	return models.ErrNoRecord
 }
+
+func (m *UserModel) VerifyEmail(ctx context.Context, id int, email string) error {
+	for _, u := range mockUsers {
+		if u.ID == id && u.Email == email {
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
+
+// ReserveVerificationEmail returns true the first time it is called for each
+// user whose address hasn't been verified, and false after that.
+func (m *UserModel) ReserveVerificationEmail(ctx context.Context, id int, interval time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == 1 || m.reserved[id] {
+		return false, nil
+	}
+
+	if m.reserved == nil {
+		m.reserved = make(map[int]bool)
+	}
+	m.reserved[id] = true
+
+	return true, nil
+}
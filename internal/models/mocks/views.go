@@ -0,0 +1,42 @@
+package mocks
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+// ViewModel keeps the counts it is given in memory, so that tests can check
+// which views were recorded.
+type ViewModel struct {
+	mu     sync.Mutex
+	counts map[int]int
+}
+
+func (m *ViewModel) Record(ctx context.Context, counts map[int]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counts == nil {
+		m.counts = make(map[int]int)
+	}
+	for id, n := range counts {
+		m.counts[id] += n
+	}
+
+	return nil
+}
+
+// Recorded returns the total number of views recorded for each snippet.
+func (m *ViewModel) Recorded() map[int]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return maps.Clone(m.counts)
+}
+
+func (m *ViewModel) Popular(ctx context.Context, days, limit int) ([]models.PopularSnippet, error) {
+	return []models.PopularSnippet{{Snippet: mockSnippet, RecentViews: 2}}, nil
+}
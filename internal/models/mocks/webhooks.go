@@ -0,0 +1,94 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/models"
+)
+
+var mockWebhook = models.Webhook{
+	ID:      1,
+	UserID:  1,
+	URL:     "https://example.com/hook",
+	Secret:  "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	Events:  []string{models.EventSnippetCreated},
+	Created: time.Now(),
+}
+
+var mockDelivery = models.WebhookDelivery{
+	ID:           1,
+	WebhookID:    1,
+	Event:        models.EventSnippetCreated,
+	Status:       models.DeliveryDelivered,
+	Attempts:     1,
+	Created:      time.Now(),
+	NextAttempt:  time.Now(),
+	LastAttempt:  time.Now(),
+	ResponseCode: 200,
+	Duration:     42 * time.Millisecond,
+}
+
+type WebhookModel struct{}
+
+func (m *WebhookModel) Insert(ctx context.Context, userID int, url string, events []string) (models.Webhook, error) {
+	w := mockWebhook
+	w.ID = 2
+	w.UserID = userID
+	w.URL = url
+	w.Events = events
+
+	return w, nil
+}
+
+func (m *WebhookModel) Get(ctx context.Context, userID, id int) (models.Webhook, error) {
+	if userID == mockWebhook.UserID && id == mockWebhook.ID {
+		return mockWebhook, nil
+	}
+
+	return models.Webhook{}, models.ErrNoRecord
+}
+
+func (m *WebhookModel) GetForUser(ctx context.Context, userID int) ([]models.Webhook, error) {
+	if userID == mockWebhook.UserID {
+		return []models.Webhook{mockWebhook}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *WebhookModel) Delete(ctx context.Context, userID, id int) error {
+	if userID == mockWebhook.UserID && id == mockWebhook.ID {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *WebhookModel) Enqueue(ctx context.Context, userID int, event string, payload []byte) (int, error) {
+	if userID == mockWebhook.UserID && mockWebhook.Subscribes(event) {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func (m *WebhookModel) EnqueueOne(ctx context.Context, webhookID int, event string, payload []byte) error {
+	return nil
+}
+
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	if webhookID == mockWebhook.ID {
+		return []models.WebhookDelivery{mockDelivery}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *WebhookModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *WebhookModel) RecordAttempt(ctx context.Context, id int, attempt models.WebhookAttempt) error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordResetModelInterface interface {
+	Insert(ctx context.Context, email string, ttl, interval time.Duration) (User, string, error)
+	Valid(ctx context.Context, plaintext string) (bool, error)
+	Reset(ctx context.Context, plaintext, newPassword string) (int, error)
+}
+
+// PasswordResetModel manages the tokens in password reset links. As with API
+// tokens, only a SHA-256 hash of each token is stored. A token can only be
+// used once, and using it cancels any others for the same user.
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a reset token for the user with the given email address,
+// which expires after ttl. It returns the user and the plain-text token, or
+// ErrNoRecord if there is no such user. To stop the user being flooded with
+// emails, it returns ErrTooManyRequests if a token was created for them less
+// than interval ago.
+func (m *PasswordResetModel) Insert(ctx context.Context, email string, ttl, interval time.Duration) (_ User, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, "", err
+	}
+	defer tx.Rollback()
+
+	var user User
+
+	// Lock the user, so that concurrent requests can't both get past the
+	// check on when the last token was created.
+	stmt := `SELECT id, name, email, created FROM users WHERE email = ? FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, stmt, email).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, "", ErrNoRecord
+		}
+		return User{}, "", err
+	}
+
+	var recent bool
+
+	stmt = `SELECT EXISTS(SELECT true FROM password_resets
+	WHERE user_id = ? AND created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	err = tx.QueryRowContext(ctx, stmt, user.ID, int(interval.Seconds())).Scan(&recent)
+	if err != nil {
+		return User{}, "", err
+	}
+	if recent {
+		return User{}, "", ErrTooManyRequests
+	}
+
+	randomBytes := make([]byte, 20)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return User{}, "", err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	stmt = `INSERT INTO password_resets (hash, user_id, created, expires)
+	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = tx.ExecContext(ctx, stmt, hashToken(plaintext), user.ID, int(ttl.Seconds()))
+	if err != nil {
+		return User{}, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return User{}, "", err
+	}
+
+	return user, plaintext, nil
+}
+
+// Valid reports whether a reset token exists and hasn't expired.
+func (m *PasswordResetModel) Valid(ctx context.Context, plaintext string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetModel.Valid")
+	defer func() { endSpan(span, err) }()
+
+	var valid bool
+
+	stmt := `SELECT EXISTS(SELECT true FROM password_resets WHERE hash = ? AND expires > UTC_TIMESTAMP())`
+
+	err = m.DB.QueryRowContext(ctx, stmt, hashToken(plaintext)).Scan(&valid)
+	return valid, err
+}
+
+// Reset uses a reset token to set a new password for the user it was made
+// for, and returns their ID. It returns ErrNoRecord if the token doesn't exist
+// or has expired. Resetting the password also ends all the user's sessions
+// (by incrementing their session version), and verifies their email address,
+// since they have just proved that they can read email sent to it.
+func (m *PasswordResetModel) Reset(ctx context.Context, plaintext, newPassword string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetModel.Reset")
+	defer func() { endSpan(span, err) }()
+
+	// Hash the password before starting the transaction, so that the token
+	// isn't locked while bcrypt does its work.
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+
+	stmt := `SELECT user_id FROM password_resets WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, stmt, hashToken(plaintext)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	stmt = `UPDATE users SET hashed_password = ?, session_version = session_version + 1, email_verified = TRUE
+	WHERE id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, string(hashedPassword), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `DELETE FROM password_resets WHERE user_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
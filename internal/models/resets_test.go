@@ -0,0 +1,61 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestPasswordResetModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := PasswordResetModel{db}
+	users := UserModel{db}
+
+	_, _, err := m.Insert(ctx, "nobody@example.com", time.Hour, time.Minute)
+	assert.Equal(t, err, ErrNoRecord)
+
+	user, token, err := m.Insert(ctx, "alice@example.com", time.Hour, time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, user.ID, 1)
+
+	// Another token can't be created straight away.
+	_, _, err = m.Insert(ctx, "alice@example.com", time.Hour, time.Minute)
+	assert.Equal(t, err, ErrTooManyRequests)
+
+	valid, err := m.Valid(ctx, token)
+	assert.NilError(t, err)
+	assert.Equal(t, valid, true)
+
+	id, err := m.Reset(ctx, token, "newPa$$word")
+	assert.NilError(t, err)
+	assert.Equal(t, id, 1)
+
+	_, err = users.Authenticate(ctx, "alice@example.com", "newPa$$word")
+	assert.NilError(t, err)
+
+	u, err := users.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, u.SessionVersion, 1)
+
+	// Tokens can only be used once.
+	_, err = m.Reset(ctx, token, "otherPa$$word")
+	assert.Equal(t, err, ErrNoRecord)
+
+	valid, err = m.Valid(ctx, token)
+	assert.NilError(t, err)
+	assert.Equal(t, valid, false)
+
+	// Expired tokens can't be used.
+	_, token, err = m.Insert(ctx, "alice@example.com", -time.Hour, 0)
+	assert.NilError(t, err)
+
+	_, err = m.Reset(ctx, token, "otherPa$$word")
+	assert.Equal(t, err, ErrNoRecord)
+}
@@ -1,55 +1,122 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 )
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (Snippet, error)
-	Latest() ([]Snippet, error)
+	Insert(ctx context.Context, userID int, title string, files []File, expires int, tags []string) (int, error)
+	Get(ctx context.Context, id int) (Snippet, error)
+	Latest(ctx context.Context) ([]Snippet, error)
+	LatestByUser(ctx context.Context, userID int) ([]Snippet, error)
+	LatestByTag(ctx context.Context, tag string) ([]Snippet, error)
+	List(ctx context.Context, page, pageSize int) ([]Snippet, int, error)
+	Update(ctx context.Context, id int, title string, files []File, expires int) error
+	Delete(ctx context.Context, id int) error
+	Fork(ctx context.Context, id, userID, expires int) (int, error)
+	Forks(ctx context.Context, id int) ([]Snippet, error)
 }
 
+// The struct tags control how a snippet is represented in the JSON API.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int       `json:"id"`
+	UserID   int       `json:"-"`                   // ID of the user who created it, or 0 if unknown
+	ParentID int       `json:"parent_id,omitempty"` // ID of the snippet it was forked from; only loaded by Get()
+	Title    string    `json:"title"`
+	Content  string    `json:"content"` // the content of the first file
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Stars    int       `json:"stars"`           // the number of users who have starred it
+	Views    int       `json:"views"`           // the number of times it has been viewed; only loaded by Get()
+	Tags     []string  `json:"tags,omitempty"`  // only loaded by Get()
+	Files    []File    `json:"files,omitempty"` // only loaded by Get()
+}
+
+// A File is one of the named files in a snippet. Every snippet has at least
+// one file.
+type File struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// DefaultFileName returns the name given to the i'th file of a snippet
+// (counting from 0) if it wasn't given one.
+func DefaultFileName(i int) string {
+	return fmt.Sprintf("snippet%d.txt", i+1)
 }
 
 type SnippetModel struct {
 	DB *sql.DB // sql.DB connection pool
 }
 
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// Insert creates a snippet with the given files, which must contain at least
+// one file.
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, files []File, expires int, tags []string) (id int, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	// The snippet, its files and its tags are inserted in a transaction, so
+	// that we never end up with a snippet which is only partly there.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO snippets (user_id, title, content, created, expires)
+    VALUES(?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
 
 	// returns a sql.Result type, which contains some
 	// basic information about what happened when the statement was executed
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	// The content of the first file is also stored in the snippets table, for
+	// the pages and feeds which only show a preview of each snippet.
+	result, err := tx.ExecContext(ctx, stmt, nullInt(userID), title, files[0].Content, expires)
 	if err != nil {
 		return 0, err
 	}
 
 	// Use the LastInsertId() method on the result to get the ID of our
 	// newly inserted record in the snippets table.
-	id, err := result.LastInsertId()
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertFiles(ctx, tx, int(lastID), files)
 	if err != nil {
 		return 0, err
 	}
 
-	return int(id), nil
+	stmt = `INSERT INTO snippet_tags (snippet_id, tag) VALUES(?, ?)`
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, stmt, lastID, tag)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(lastID), nil
 }
 
-func (m *SnippetModel) Get(id int) (Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
+func (m *SnippetModel) Get(ctx context.Context, id int) (_ Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, parent_id, title, content, created, expires, stars, views FROM snippets
     WHERE expires > UTC_TIMESTAMP() AND id = ?`
 
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRowContext(ctx, stmt, id)
 
 	var s Snippet
 
@@ -58,7 +125,8 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 	// to row.Scan are *pointers* to the place you want to copy the data into,
 	// and the number of arguments must be exactly the same as the number of
 	// columns returned by your statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	var userID, parentID sql.NullInt64
+	err = row.Scan(&s.ID, &userID, &parentID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Stars, &s.Views)
 	if err != nil {
 		// If the query returns no rows, then row.Scan() will return a
 		// sql.ErrNoRows error. We use the errors.Is() function check for that
@@ -70,15 +138,116 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 			return Snippet{}, err
 		}
 	}
+	s.UserID = int(userID.Int64)
+	s.ParentID = int(parentID.Int64)
+
+	s.Tags, err = m.tags(ctx, s.ID)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	err = m.loadFiles(ctx, &s)
+	if err != nil {
+		return Snippet{}, err
+	}
 
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
+// loadFiles sets the Files of a snippet.
+func (m *SnippetModel) loadFiles(ctx context.Context, s *Snippet) error {
+	files, err := m.files(ctx, s.ID)
+	if err != nil {
+		return err
+	}
+
+	// Snippets created before snippets could have several files don't have
+	// any rows in snippet_files, so we make up their only file.
+	if len(files) == 0 {
+		files = []File{{Name: DefaultFileName(0), Language: "plaintext", Content: s.Content}}
+	}
+
+	s.Files = files
+	return nil
+}
+
+// files returns the files of a snippet in order.
+func (m *SnippetModel) files(ctx context.Context, id int) ([]File, error) {
+	stmt := `SELECT name, language, content FROM snippet_files WHERE snippet_id = ? ORDER BY position`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		err = rows.Scan(&f.Name, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// insertFiles stores the files of a snippet, numbering their positions from
+// 0.
+func insertFiles(ctx context.Context, tx *sql.Tx, id int, files []File) error {
+	stmt := `INSERT INTO snippet_files (snippet_id, position, name, language, content) VALUES(?, ?, ?, ?, ?)`
+
+	for i, f := range files {
+		_, err := tx.ExecContext(ctx, stmt, id, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tags returns the tags of a snippet in alphabetical order.
+func (m *SnippetModel) tags(ctx context.Context, id int) ([]string, error) {
+	stmt := `SELECT tag FROM snippet_tags WHERE snippet_id = ? ORDER BY tag`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (m *SnippetModel) Latest(ctx context.Context) (_ []Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Latest")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, title, content, created, expires, stars FROM snippets
 	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
 
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt)
 	if err != nil {
 		return nil, err
 	}
@@ -87,20 +256,259 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 	// trying to close a nil resultset.
 	defer rows.Close()
 
+	return scanSnippets(rows)
+}
+
+// LatestByUser returns the 10 most recently created unexpired snippets
+// belonging to the user.
+func (m *SnippetModel) LatestByUser(ctx context.Context, userID int) (_ []Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.LatestByUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, title, content, created, expires, stars FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND user_id = ? ORDER BY id DESC LIMIT 10`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// LatestByTag returns the 10 most recently created unexpired snippets with
+// the given tag.
+func (m *SnippetModel) LatestByTag(ctx context.Context, tag string) (_ []Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.LatestByTag")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires, s.stars
+	FROM snippets s INNER JOIN snippet_tags t ON t.snippet_id = s.id
+	WHERE s.expires > UTC_TIMESTAMP() AND t.tag = ? ORDER BY s.id DESC LIMIT 10`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// List returns a page of unexpired snippets, newest first, along with the
+// total number of unexpired snippets. Pages are numbered from 1.
+func (m *SnippetModel) List(ctx context.Context, page, pageSize int) (_ []Snippet, total int, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.List")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT COUNT(*) FROM snippets WHERE expires > UTC_TIMESTAMP()`
+
+	err = m.DB.QueryRowContext(ctx, stmt).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt = `SELECT id, user_id, title, content, created, expires, stars FROM snippets
+	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	snippets, err := scanSnippets(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return snippets, total, nil
+}
+
+// Update replaces the title and files of an unexpired snippet, and sets it to
+// expire the given number of days from now.
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, files []File, expires int) (err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Update")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE snippets SET title = ?, content = ?, expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)
+	WHERE expires > UTC_TIMESTAMP() AND id = ?`
+
+	result, err := tx.ExecContext(ctx, stmt, title, files[0].Content, expires, id)
+	if err != nil {
+		return err
+	}
+
+	// MySQL only counts rows which were actually changed, so an update which
+	// doesn't change anything also affects zero rows. Check whether the
+	// snippet exists before reporting that it doesn't.
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?)`
+
+		err = tx.QueryRowContext(ctx, stmt, id).Scan(&exists)
+		if err == nil && !exists {
+			err = ErrNoRecord
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replace the files rather than working out which ones changed.
+	stmt = `DELETE FROM snippet_files WHERE snippet_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertFiles(ctx, tx, id, files)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Fork copies an unexpired snippet, with its files and tags, into a new
+// snippet belonging to the user which expires the given number of days from
+// now. The new snippet records the snippet it was forked from.
+func (m *SnippetModel) Fork(ctx context.Context, id, userID, expires int) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Fork")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Copy the rows with INSERT ... SELECT, so that the snippet can't change
+	// between reading and copying it.
+	stmt := `INSERT INTO snippets (user_id, parent_id, title, content, created, expires)
+	SELECT ?, id, title, content, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)
+	FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+
+	result, err := tx.ExecContext(ctx, stmt, nullInt(userID), expires, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkRowsAffected(result)
+	if err != nil {
+		return 0, err
+	}
+
+	forkID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	SELECT ?, position, name, language, content FROM snippet_files WHERE snippet_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, forkID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO snippet_tags (snippet_id, tag) SELECT ?, tag FROM snippet_tags WHERE snippet_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, forkID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(forkID), nil
+}
+
+// Forks returns the unexpired snippets which were forked from a snippet,
+// newest first.
+func (m *SnippetModel) Forks(ctx context.Context, id int) (_ []Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Forks")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, title, content, created, expires, stars FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND parent_id = ? ORDER BY id DESC LIMIT 50`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// scanSnippets reads all the rows of a query which selects the id, user_id,
+// title, content, created, expires and stars columns, in that order.
+func scanSnippets(rows *sql.Rows) ([]Snippet, error) {
 	var snippets []Snippet
 	for rows.Next() {
-		var s Snippet
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		var (
+			s      Snippet
+			userID sql.NullInt64
+		)
+		err := rows.Scan(&s.ID, &userID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Stars)
 		if err != nil {
 			return nil, err
 		}
+		s.UserID = int(userID.Int64)
 
 		snippets = append(snippets, s)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
 	return snippets, nil
 }
+
+// checkRowsAffected returns ErrNoRecord if an UPDATE or DELETE statement
+// didn't match any rows.
+func checkRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// nullInt converts an ID to a value for a nullable column, using NULL for 0.
+func nullInt(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
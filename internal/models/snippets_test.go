@@ -0,0 +1,125 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestSnippetModelUpdateDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := SnippetModel{newTestDB(t)}
+
+	// Updating with the same values doesn't change any rows, but must not
+	// be reported as a missing record.
+	for range 2 {
+		err := m.Update(ctx, 1, "An old silent pond", []File{{Name: "pond.txt", Language: "plaintext", Content: "A frog jumps into the pond"}}, 7)
+		assert.NilError(t, err)
+	}
+
+	s, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Content, "A frog jumps into the pond")
+	assert.Equal(t, len(s.Files), 1)
+	assert.Equal(t, s.Files[0].Name, "pond.txt")
+	assert.Equal(t, s.UserID, 1)
+
+	err = m.Update(ctx, 2, "title", []File{{Name: "a.txt", Content: "content"}}, 7)
+	assert.Equal(t, err, ErrNoRecord)
+
+	err = m.Delete(ctx, 1)
+	assert.NilError(t, err)
+
+	err = m.Delete(ctx, 1)
+	assert.Equal(t, err, ErrNoRecord)
+
+	snippets, total, err := m.List(ctx, 1, 20)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 0)
+	assert.Equal(t, total, 0)
+}
+
+func TestSnippetModelFork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := SnippetModel{newTestDB(t)}
+
+	files := []File{
+		{Name: "Dockerfile", Language: "dockerfile", Content: "FROM scratch"},
+		{Name: "run.sh", Language: "bash", Content: "docker build ."},
+	}
+
+	id, err := m.Insert(ctx, 1, "Docker setup", files, 7, []string{"docker"})
+	assert.NilError(t, err)
+
+	forkID, err := m.Fork(ctx, id, 1, 365)
+	assert.NilError(t, err)
+
+	fork, err := m.Get(ctx, forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, fork.ParentID, id)
+	assert.Equal(t, fork.UserID, 1)
+	assert.Equal(t, fork.Title, "Docker setup")
+	assert.Equal(t, len(fork.Files), 2)
+	assert.Equal(t, fork.Files[1], files[1])
+	assert.Equal(t, len(fork.Tags), 1)
+	assert.Equal(t, fork.Tags[0], "docker")
+
+	forks, err := m.Forks(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 1)
+	assert.Equal(t, forks[0].ID, forkID)
+
+	_, err = m.Fork(ctx, 99, 1, 365)
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Deleting the original keeps the fork, without its parent.
+	err = m.Delete(ctx, id)
+	assert.NilError(t, err)
+
+	fork, err = m.Get(ctx, forkID)
+	assert.NilError(t, err)
+	assert.Equal(t, fork.ParentID, 0)
+}
+
+func TestSnippetModelTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := SnippetModel{newTestDB(t)}
+
+	id, err := m.Insert(ctx, 1, "Over the wintry forest", []File{{Name: "winter.txt", Content: "Over the wintry forest..."}}, 7, []string{"haiku", "winter"})
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(s.Tags, ","), "haiku,winter")
+
+	snippets, err := m.LatestByTag(ctx, "haiku")
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 2)
+	assert.Equal(t, snippets[0].ID, id)
+
+	snippets, err = m.LatestByTag(ctx, "autumn")
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 0)
+
+	snippets, err = m.LatestByUser(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 2)
+
+	snippets, err = m.LatestByUser(ctx, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 0)
+}
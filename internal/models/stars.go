@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type StarModelInterface interface {
+	Star(ctx context.Context, userID, snippetID int) error
+	Unstar(ctx context.Context, userID, snippetID int) error
+	Starred(ctx context.Context, userID, snippetID int) (bool, error)
+	ByUser(ctx context.Context, userID int) ([]Snippet, error)
+}
+
+// StarModel records which users have starred which snippets. The number of
+// stars each snippet has is kept in snippets.stars, so that listings don't
+// need to count the rows in the stars table; it is always changed in the same
+// transaction as the stars table. If snippet caching is switched on, wrap it
+// with CachedSnippetModel.StarModel() so that the cached counts are dropped.
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Star adds a star to an unexpired snippet. Starring a snippet which the user
+// has already starred does nothing, so it is safe to repeat.
+func (m *StarModel) Star(ctx context.Context, userID, snippetID int) (err error) {
+	ctx, span := tracer.Start(ctx, "StarModel.Star")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The insert does nothing (and affects no rows) if the user has already
+	// starred the snippet, or if the snippet doesn't exist.
+	stmt := `INSERT INTO stars (user_id, snippet_id, created)
+	SELECT ?, id, UTC_TIMESTAMP() FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?
+	ON DUPLICATE KEY UPDATE user_id = user_id`
+
+	result, err := tx.ExecContext(ctx, stmt, userID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?)`
+
+		err = tx.QueryRowContext(ctx, stmt, snippetID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+
+		// Already starred.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE snippets SET stars = stars + 1 WHERE id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, snippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unstar removes the user's star from a snippet. Like Star(), it does
+// nothing if there is no star to remove.
+func (m *StarModel) Unstar(ctx context.Context, userID, snippetID int) (err error) {
+	ctx, span := tracer.Start(ctx, "StarModel.Unstar")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`
+
+	result, err := tx.ExecContext(ctx, stmt, userID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE snippets SET stars = stars - 1 WHERE id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, snippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Starred reports whether the user has starred the snippet.
+func (m *StarModel) Starred(ctx context.Context, userID, snippetID int) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "StarModel.Starred")
+	defer func() { endSpan(span, err) }()
+
+	var starred bool
+
+	stmt := `SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, snippetID).Scan(&starred)
+	return starred, err
+}
+
+// ByUser returns the unexpired snippets which the user has starred, most
+// recently starred first. Only the first 100 are returned.
+func (m *StarModel) ByUser(ctx context.Context, userID int) (_ []Snippet, err error) {
+	ctx, span := tracer.Start(ctx, "StarModel.ByUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires, s.stars
+	FROM snippets s INNER JOIN stars st ON st.snippet_id = s.id
+	WHERE s.expires > UTC_TIMESTAMP() AND st.user_id = ?
+	ORDER BY st.created DESC, s.id DESC LIMIT 100`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
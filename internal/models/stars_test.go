@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestStarModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := StarModel{db}
+	snippets := SnippetModel{db}
+
+	// Starring twice only counts once.
+	for range 2 {
+		err := m.Star(ctx, 1, 1)
+		assert.NilError(t, err)
+	}
+
+	starred, err := m.Starred(ctx, 1, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, true)
+
+	s, err := snippets.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Stars, 1)
+
+	starredSnippets, err := m.ByUser(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(starredSnippets), 1)
+	assert.Equal(t, starredSnippets[0].ID, 1)
+	assert.Equal(t, starredSnippets[0].Stars, 1)
+
+	err = m.Star(ctx, 1, 2)
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Unstarring twice only counts once, too.
+	for range 2 {
+		err = m.Unstar(ctx, 1, 1)
+		assert.NilError(t, err)
+	}
+
+	starred, err = m.Starred(ctx, 1, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, false)
+
+	s, err = snippets.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Stars, 0)
+}
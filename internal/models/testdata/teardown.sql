@@ -1,3 +1,27 @@
+DROP TABLE recovery_codes;
+
+DROP TABLE password_resets;
+
+DROP TABLE snippet_views;
+
+DROP TABLE collection_snippets;
+
+DROP TABLE collections;
+
+DROP TABLE comments;
+
+DROP TABLE stars;
+
+DROP TABLE webhook_deliveries;
+
+DROP TABLE webhooks;
+
+DROP TABLE tokens;
+
 DROP TABLE users;
 
+DROP TABLE snippet_files;
+
+DROP TABLE snippet_tags;
+
 DROP TABLE snippets;
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// Token scopes. Each scope includes the permissions of the ones before it, so
+// an admin token can also be used to read and write snippets.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// Every plain-text token starts with this prefix, which makes leaked tokens
+// easy to recognise (e.g. by secret scanners).
+const tokenPrefix = "sbx_"
+
+var scopeRank = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+type TokenModelInterface interface {
+	Insert(ctx context.Context, userID int, name, scope string, ttl time.Duration) (Token, error)
+	Authenticate(ctx context.Context, plaintext string) (Token, error)
+	GetForUser(ctx context.Context, userID int) ([]Token, error)
+	Delete(ctx context.Context, userID, id int) error
+}
+
+// A Token is a personal access token. Only a SHA-256 hash of the token is
+// stored in the database; Plaintext is only set on the value returned by
+// Insert(), which is the one and only time it is available.
+type Token struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	LastUsed  time.Time `json:"last_used"` // zero if the token has never been used
+	Plaintext string    `json:"token,omitempty"`
+}
+
+// Allows reports whether the token grants the given scope.
+func (t Token) Allows(scope string) bool {
+	return scopeRank[t.Scope] >= scopeRank[scope]
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Insert generates a new token for the user, stores its hash and returns the
+// token including its plain-text value.
+func (m *TokenModel) Insert(ctx context.Context, userID int, name, scope string, ttl time.Duration) (_ Token, err error) {
+	ctx, span := tracer.Start(ctx, "TokenModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	// 20 random bytes give us 160 bits of entropy, which encode to 32
+	// characters of base32 without any padding.
+	randomBytes := make([]byte, 20)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return Token{}, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	t := Token{
+		UserID:    userID,
+		Name:      name,
+		Scope:     scope,
+		Created:   now,
+		Expires:   now.Add(ttl),
+		Plaintext: tokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+	}
+
+	stmt := `INSERT INTO tokens (user_id, name, hash, scope, created, expires) VALUES(?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, t.UserID, t.Name, hashToken(t.Plaintext), t.Scope, t.Created, t.Expires)
+	if err != nil {
+		return Token{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Token{}, err
+	}
+	t.ID = int(id)
+
+	return t, nil
+}
+
+// Authenticate looks up an unexpired token by its plain-text value, returning
+// ErrInvalidCredentials if there is no such token. It also records that the
+// token has been used.
+func (m *TokenModel) Authenticate(ctx context.Context, plaintext string) (_ Token, err error) {
+	ctx, span := tracer.Start(ctx, "TokenModel.Authenticate")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT tokens.id, tokens.user_id, tokens.name, tokens.scope, tokens.created, tokens.expires, tokens.last_used
+	FROM tokens INNER JOIN users ON users.id = tokens.user_id
+	WHERE tokens.hash = ? AND tokens.expires > UTC_TIMESTAMP()`
+
+	var (
+		t        Token
+		lastUsed sql.NullTime
+	)
+
+	err = m.DB.QueryRowContext(ctx, stmt, hashToken(plaintext)).Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Created, &t.Expires, &lastUsed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, ErrInvalidCredentials
+		}
+		return Token{}, err
+	}
+	t.LastUsed = lastUsed.Time
+
+	// Only update the timestamp once a minute, so that a busy script doesn't
+	// cause a write for every single request.
+	stmt = `UPDATE tokens SET last_used = UTC_TIMESTAMP()
+	WHERE id = ? AND (last_used IS NULL OR last_used < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE))`
+
+	_, err = m.DB.ExecContext(ctx, stmt, t.ID)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return t, nil
+}
+
+// GetForUser returns all the user's tokens (including expired ones), newest
+// first.
+func (m *TokenModel) GetForUser(ctx context.Context, userID int) (_ []Token, err error) {
+	ctx, span := tracer.Start(ctx, "TokenModel.GetForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, name, scope, created, expires, last_used FROM tokens
+	WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var (
+			t        Token
+			lastUsed sql.NullTime
+		)
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Created, &t.Expires, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete revokes one of the user's tokens. It returns ErrNoRecord if the
+// token doesn't exist or belongs to somebody else.
+func (m *TokenModel) Delete(ctx context.Context, userID, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "TokenModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM tokens WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// hashToken returns the SHA-256 hash of a plain-text token. Unlike passwords,
+// tokens are long random strings, so a fast hash is sufficient.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  map[string]bool
+	}{
+		{ScopeRead, map[string]bool{ScopeRead: true, ScopeWrite: false, ScopeAdmin: false}},
+		{ScopeWrite, map[string]bool{ScopeRead: true, ScopeWrite: true, ScopeAdmin: false}},
+		{ScopeAdmin, map[string]bool{ScopeRead: true, ScopeWrite: true, ScopeAdmin: true}},
+		{"bogus", map[string]bool{ScopeRead: false, ScopeWrite: false, ScopeAdmin: false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			token := Token{Scope: tt.scope}
+			for scope, want := range tt.want {
+				assert.Equal(t, token.Allows(scope), want)
+			}
+		})
+	}
+}
+
+func TestTokenModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := TokenModel{newTestDB(t)}
+
+	token, err := m.Insert(ctx, 1, "CI", ScopeWrite, time.Hour)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(token.Plaintext, tokenPrefix), true)
+
+	got, err := m.Authenticate(ctx, token.Plaintext)
+	assert.NilError(t, err)
+	assert.Equal(t, got.ID, token.ID)
+	assert.Equal(t, got.Scope, ScopeWrite)
+
+	_, err = m.Authenticate(ctx, token.Plaintext+"x")
+	assert.Equal(t, err, ErrInvalidCredentials)
+
+	tokens, err := m.GetForUser(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 1)
+	assert.Equal(t, tokens[0].LastUsed.IsZero(), false)
+
+	// Other users can't revoke the token.
+	err = m.Delete(ctx, 2, token.ID)
+	assert.Equal(t, err, ErrNoRecord)
+
+	err = m.Delete(ctx, 1, token.ID)
+	assert.NilError(t, err)
+
+	_, err = m.Authenticate(ctx, token.Plaintext)
+	assert.Equal(t, err, ErrInvalidCredentials)
+}
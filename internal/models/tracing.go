@@ -0,0 +1,25 @@
+package models
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Every model method starts a child span of the span in its context. Until a
+// tracer provider is configured by the application the global one is a no-op,
+// so this costs next to nothing in tests.
+var tracer = otel.Tracer("snippetbox.dkimhw.com/internal/models")
+
+// endSpan records err on the span (if it is an unexpected error) and ends it.
+// Errors such as ErrNoRecord are part of normal operation, so they don't mark
+// the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNoRecord) && !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrDuplicateEmail) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
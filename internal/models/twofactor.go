@@ -0,0 +1,305 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The number of recovery codes a user is given when they enable two-factor
+// authentication, or ask for new ones.
+const recoveryCodeCount = 10
+
+type TwoFactorModelInterface interface {
+	Secret(ctx context.Context, userID int) (string, error)
+	Enable(ctx context.Context, userID int, secret string) ([]string, error)
+	Disable(ctx context.Context, userID int, password string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID int, password string) ([]string, error)
+	RecoveryCodesLeft(ctx context.Context, userID int) (int, error)
+	UseStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+}
+
+// TwoFactorModel manages users' TOTP secrets and recovery codes. The secret
+// has to be stored as it is, since it is needed to check codes, but as with
+// API tokens only a SHA-256 hash of each recovery code is stored. Checking
+// the codes themselves is left to the caller.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Secret returns the user's TOTP secret, or ErrNoRecord if they haven't
+// enabled two-factor authentication.
+func (m *TwoFactorModel) Secret(ctx context.Context, userID int) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Secret")
+	defer func() { endSpan(span, err) }()
+
+	var secret sql.NullString
+
+	stmt := `SELECT totp_secret FROM users WHERE id = ?`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	if !secret.Valid {
+		return "", ErrNoRecord
+	}
+
+	return secret.String, nil
+}
+
+// Enable turns on two-factor authentication for the user with the given TOTP
+// secret, and returns their new recovery codes. It returns
+// ErrTwoFactorEnabled if it is already on, rather than replacing the secret.
+func (m *TwoFactorModel) Enable(ctx context.Context, userID int, secret string) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Enable")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_secret IS NULL`
+
+	result, err := tx.ExecContext(ctx, stmt, secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM users WHERE id = ?)`
+
+		err = tx.QueryRowContext(ctx, stmt, userID).Scan(&exists)
+		if err == nil {
+			err = ErrNoRecord
+			if exists {
+				err = ErrTwoFactorEnabled
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication for the user and deletes their
+// recovery codes, as long as password is their current password. Otherwise it
+// returns ErrInvalidCredentials.
+func (m *TwoFactorModel) Disable(ctx context.Context, userID int, password string) (err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Disable")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = checkTwoFactorPassword(ctx, tx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_last_step = NULL WHERE id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `DELETE FROM recovery_codes WHERE user_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones,
+// and returns them. As with Disable, password must be their current password.
+// It returns ErrNoRecord if they haven't enabled two-factor authentication.
+func (m *TwoFactorModel) RegenerateRecoveryCodes(ctx context.Context, userID int, password string) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.RegenerateRecoveryCodes")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	enabled, err := checkTwoFactorPassword(ctx, tx, userID, password)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrNoRecord
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns how many of the user's recovery codes haven't
+// been used yet.
+func (m *TwoFactorModel) RecoveryCodesLeft(ctx context.Context, userID int) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.RecoveryCodesLeft")
+	defer func() { endSpan(span, err) }()
+
+	var n int
+
+	stmt := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID).Scan(&n)
+	return n, err
+}
+
+// UseStep records that the user has logged in with the TOTP code for the
+// given time step, and returns true, unless they have already used a code for
+// that step or a later one. This stops a code from being used twice, such as
+// by someone who has watched it being typed in.
+func (m *TwoFactorModel) UseStep(ctx context.Context, userID int, step int64) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.UseStep")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE users SET totp_last_step = ?
+	WHERE id = ? AND totp_secret IS NOT NULL AND (totp_last_step IS NULL OR totp_last_step < ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UseRecoveryCode deletes one of the user's recovery codes, and returns true,
+// if code matches it. Codes are matched regardless of case, spaces and
+// dashes.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, code string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.UseRecoveryCode")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// checkTwoFactorPassword returns ErrInvalidCredentials unless password is the
+// user's current password, and otherwise reports whether they have enabled
+// two-factor authentication. The user is locked until tx ends.
+func checkTwoFactorPassword(ctx context.Context, tx *sql.Tx, userID int, password string) (bool, error) {
+	var hashedPassword []byte
+	var enabled bool
+
+	stmt := `SELECT hashed_password, totp_secret IS NOT NULL FROM users WHERE id = ? FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, stmt, userID).Scan(&hashedPassword, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrInvalidCredentials
+		}
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and creates new ones,
+// which it returns. Each code is 8 random base32 characters (40 bits), written
+// in two groups of four to make it easier to copy down.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ?`
+
+	_, err := tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)`
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		_, err = rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+
+		_, err = tx.ExecContext(ctx, stmt, userID, hashToken(code))
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the formatting from a recovery code which the
+// user has typed in.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestTwoFactorModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := TwoFactorModel{db}
+	users := UserModel{db}
+
+	id, err := users.Insert(ctx, "Carol", "carol@example.com", "pa$$word")
+	assert.NilError(t, err)
+
+	_, err = m.Secret(ctx, id)
+	assert.Equal(t, err, ErrNoRecord)
+
+	codes, err := m.Enable(ctx, id, "JBSWY3DPEHPK3PXP")
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), recoveryCodeCount)
+
+	// The secret isn't replaced by enabling it again.
+	_, err = m.Enable(ctx, id, "KRUGS4ZANFZSA5DIMU")
+	assert.Equal(t, err, ErrTwoFactorEnabled)
+
+	secret, err := m.Secret(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	user, err := users.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.TwoFactorEnabled, true)
+
+	// Each time step can only be used once, and earlier ones can't be used
+	// after a later one.
+	ok, err := m.UseStep(ctx, id, 100)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	for _, step := range []int64{100, 99} {
+		ok, err = m.UseStep(ctx, id, step)
+		assert.NilError(t, err)
+		assert.Equal(t, ok, false)
+	}
+
+	// Recovery codes can be typed in without the dash, and in upper case,
+	// but only used once.
+	ok, err = m.UseRecoveryCode(ctx, id, strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")))
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	ok, err = m.UseRecoveryCode(ctx, id, codes[0])
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	n, err := m.RecoveryCodesLeft(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, n, recoveryCodeCount-1)
+
+	// Regenerating the codes needs the password, and replaces the old ones.
+	_, err = m.RegenerateRecoveryCodes(ctx, id, "wrong")
+	assert.Equal(t, err, ErrInvalidCredentials)
+
+	newCodes, err := m.RegenerateRecoveryCodes(ctx, id, "pa$$word")
+	assert.NilError(t, err)
+	assert.Equal(t, len(newCodes), recoveryCodeCount)
+
+	ok, err = m.UseRecoveryCode(ctx, id, codes[1])
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	// So does disabling it.
+	err = m.Disable(ctx, id, "wrong")
+	assert.Equal(t, err, ErrInvalidCredentials)
+
+	err = m.Disable(ctx, id, "pa$$word")
+	assert.NilError(t, err)
+
+	user, err = users.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.TwoFactorEnabled, false)
+
+	n, err = m.RecoveryCodesLeft(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+
+	_, err = m.RegenerateRecoveryCodes(ctx, id, "pa$$word")
+	assert.Equal(t, err, ErrNoRecord)
+}
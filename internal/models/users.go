@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
@@ -11,11 +12,13 @@ import (
 )
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (User, error)
-	PasswordUpdate(id int, currentPassword, newPassword string) error
+	Insert(ctx context.Context, name, email, password string) (int, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (User, error)
+	PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error
+	VerifyEmail(ctx context.Context, id int, email string) error
+	ReserveVerificationEmail(ctx context.Context, id int, interval time.Duration) (bool, error)
 }
 
 // New user struct
@@ -25,6 +28,11 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	EmailVerified  bool // whether the user has proved that they own Email
+	SessionVersion int  // incremented to end all the user's sessions, e.g. when their password is reset
+	// Whether the user has enabled two-factor authentication, and so must
+	// enter a TOTP or recovery code when they log in.
+	TwoFactorEnabled bool
 }
 
 // Define a new UserModel struct which wraps a database connection pool.
@@ -33,33 +41,45 @@ type UserModel struct {
 }
 
 // Use Insert method to add a new record to the "users" table.
-func (m *UserModel) Insert(name, email, password string) error {
+// It returns the ID of the new user.
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.Insert")
+	defer func() { endSpan(span, err) }()
+
 	// Create a bcrypt hash of the plain-text password.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
 	// Use the Exec() method to insert the user details and hashed password into the users table.
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.ExecContext(ctx, stmt, name, email, string(hashedPassword))
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 // Use Authenticate method to verify whether a user exists with the
 // provided email address and password.
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.Authenticate")
+	defer func() { endSpan(span, err) }()
+
 	// Retrieve the id and hashed password associated with the given email.
 	// If no matching email exists we return the ErrInvalidCredentials erro.
 	var id int
@@ -67,7 +87,7 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 
 	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err = m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -91,21 +111,28 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 }
 
 // Check if a user exists with a specific ID.
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.Exists")
+	defer func() { endSpan(span, err) }()
+
 	var exists bool
 
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
 
-func (m *UserModel) Get(id int) (User, error) {
+func (m *UserModel) Get(ctx context.Context, id int) (_ User, err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.Get")
+	defer func() { endSpan(span, err) }()
+
 	var user User
 
-	stmt := `SELECT id, name, email, created FROM users WHERE id = ?`
+	stmt := `SELECT id, name, email, created, email_verified, session_version, totp_secret IS NOT NULL
+	FROM users WHERE id = ?`
 
-	err := m.DB.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.EmailVerified, &user.SessionVersion, &user.TwoFactorEnabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, ErrNoRecord
@@ -117,12 +144,15 @@ func (m *UserModel) Get(id int) (User, error) {
 	return user, nil
 }
 
-func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.PasswordUpdate")
+	defer func() { endSpan(span, err) }()
+
 	var currentHashedPassword []byte
 
 	stmt := "SELECT hashed_password FROM users WHERE id = ?"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&currentHashedPassword)
+	err = m.DB.QueryRowContext(ctx, stmt, id).Scan(&currentHashedPassword)
 	if err != nil {
 		return err
 	}
@@ -143,6 +173,61 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 
 	stmt = "UPDATE users SET hashed_password = ? WHERE id = ?"
 
-	_, err = m.DB.Exec(stmt, string(newHashedPassword), id)
+	_, err = m.DB.ExecContext(ctx, stmt, string(newHashedPassword), id)
+	return err
+}
+
+// VerifyEmail marks the user's email address as verified, as long as it is
+// still the given one. Verifying an address twice is harmless.
+func (m *UserModel) VerifyEmail(ctx context.Context, id int, email string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.VerifyEmail")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, email)
+	if err != nil {
+		return err
+	}
+
+	// Nothing is changed if the address was already verified, so check
+	// whether it is there before reporting that it isn't.
+	err = checkRowsAffected(result)
+	if errors.Is(err, ErrNoRecord) {
+		var exists bool
+
+		stmt = `SELECT EXISTS(SELECT true FROM users WHERE id = ? AND email = ?)`
+
+		err = m.DB.QueryRowContext(ctx, stmt, id, email).Scan(&exists)
+		if err == nil && !exists {
+			err = ErrNoRecord
+		}
+	}
+
 	return err
 }
+
+// ReserveVerificationEmail records that a verification email is about to be
+// sent to the user, and returns true, unless one was sent less than interval
+// ago or their address has already been verified. Doing the check and the
+// update in one statement means that concurrent requests can't both send one.
+func (m *UserModel) ReserveVerificationEmail(ctx context.Context, id int, interval time.Duration) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "UserModel.ReserveVerificationEmail")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `UPDATE users SET verification_sent = UTC_TIMESTAMP()
+	WHERE id = ? AND email_verified = FALSE
+	AND (verification_sent IS NULL OR verification_sent <= DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, int(interval.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"snippetbox.dkimhw.com/internal/assert"
 )
@@ -48,10 +50,50 @@ func TestUserModelExists(t *testing.T) {
 
 			// Call the UserModel.Exists() method and check that the return
 			// value and error match the expected values for the sub-test.
-			exists, err := m.Exists(tt.userID)
+			exists, err := m.Exists(context.Background(), tt.userID)
 
 			assert.Equal(t, exists, tt.want)
 			assert.NilError(t, err)
 		})
 	}
 }
+
+func TestUserModelVerifyEmail(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := UserModel{db}
+
+	id, err := m.Insert(ctx, "Carol", "carol@example.com", "pa$$word")
+	assert.NilError(t, err)
+
+	// Only one verification email can be sent at a time.
+	ok, err := m.ReserveVerificationEmail(ctx, id, time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	ok, err = m.ReserveVerificationEmail(ctx, id, time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	err = m.VerifyEmail(ctx, id, "carol@example.org")
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Verifying twice is fine.
+	for range 2 {
+		err = m.VerifyEmail(ctx, id, "carol@example.com")
+		assert.NilError(t, err)
+	}
+
+	user, err := m.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.EmailVerified, true)
+
+	// Verified users aren't sent any more verification emails.
+	ok, err = m.ReserveVerificationEmail(ctx, id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+}
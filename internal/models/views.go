@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+)
+
+type ViewModelInterface interface {
+	Record(ctx context.Context, counts map[int]int) error
+	Popular(ctx context.Context, days, limit int) ([]PopularSnippet, error)
+}
+
+// A PopularSnippet is a snippet along with the number of times it has been
+// viewed recently.
+type PopularSnippet struct {
+	Snippet
+	RecentViews int
+}
+
+// ViewModel keeps count of how many times snippets have been viewed. The
+// total is kept in snippets.views, and the views on each day in
+// snippet_views, which is what the popular snippets are ranked by.
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Record adds to the view counts of snippets, given the number of new views
+// of each one keyed by snippet ID. Counts for snippets which no longer exist
+// are ignored.
+func (m *ViewModel) Record(ctx context.Context, counts map[int]int) (err error) {
+	ctx, span := tracer.Start(ctx, "ViewModel.Record")
+	defer func() { endSpan(span, err) }()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Update the rows in order of snippet ID, so that two instances of the
+	// application flushing at the same time can't deadlock.
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		stmt := `INSERT INTO snippet_views (snippet_id, day, views)
+		SELECT id, UTC_DATE(), ? FROM snippets WHERE id = ?
+		ON DUPLICATE KEY UPDATE views = snippet_views.views + ?`
+
+		_, err = tx.ExecContext(ctx, stmt, counts[id], id, counts[id])
+		if err != nil {
+			return err
+		}
+
+		stmt = `UPDATE snippets SET views = views + ? WHERE id = ?`
+
+		_, err = tx.ExecContext(ctx, stmt, counts[id], id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Popular returns the unexpired snippets with the most views over the last
+// given number of days (including today), most viewed first.
+func (m *ViewModel) Popular(ctx context.Context, days, limit int) (_ []PopularSnippet, err error) {
+	ctx, span := tracer.Start(ctx, "ViewModel.Popular")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT s.id, s.user_id, s.title, s.content, s.created, s.expires, s.stars, SUM(v.views) AS recent_views
+	FROM snippets s INNER JOIN snippet_views v ON v.snippet_id = s.id
+	WHERE s.expires > UTC_TIMESTAMP() AND v.day > DATE_SUB(UTC_DATE(), INTERVAL ? DAY)
+	GROUP BY s.id ORDER BY recent_views DESC, s.id DESC LIMIT ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, days, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []PopularSnippet
+	for rows.Next() {
+		var (
+			s      PopularSnippet
+			userID sql.NullInt64
+		)
+		err = rows.Scan(&s.ID, &userID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Stars, &s.RecentViews)
+		if err != nil {
+			return nil, err
+		}
+		s.UserID = int(userID.Int64)
+
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
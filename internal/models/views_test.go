@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestViewModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	m := ViewModel{db}
+	snippets := SnippetModel{db}
+
+	second, err := snippets.Insert(ctx, 1, "Over the wintry forest", []File{{Name: "winter.txt", Content: "Over the wintry forest..."}}, 7, nil)
+	assert.NilError(t, err)
+
+	// Counts for the same snippet and day are added together, and counts for
+	// snippets which don't exist are ignored.
+	err = m.Record(ctx, map[int]int{1: 2, second: 1, 99: 5})
+	assert.NilError(t, err)
+
+	err = m.Record(ctx, map[int]int{second: 3})
+	assert.NilError(t, err)
+
+	s, err := snippets.Get(ctx, second)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Views, 4)
+
+	popular, err := m.Popular(ctx, 7, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(popular), 2)
+	assert.Equal(t, popular[0].ID, second)
+	assert.Equal(t, popular[0].RecentViews, 4)
+	assert.Equal(t, popular[1].ID, 1)
+	assert.Equal(t, popular[1].RecentViews, 2)
+
+	popular, err = m.Popular(ctx, 7, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(popular), 1)
+}
@@ -0,0 +1,395 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The events which webhooks can subscribe to. EventPing is only ever sent by
+// the "send test event" button, to whichever webhook it was pressed for.
+const (
+	EventSnippetCreated = "snippet.created"
+	EventSnippetUpdated = "snippet.updated"
+	EventSnippetDeleted = "snippet.deleted"
+	EventCommentCreated = "comment.created" // someone else commented on one of the user's snippets
+	EventPing           = "ping"
+)
+
+// WebhookEvents lists the events which users can subscribe to.
+var WebhookEvents = []string{EventSnippetCreated, EventSnippetUpdated, EventSnippetDeleted, EventCommentCreated}
+
+// The states of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // given up after too many attempts
+)
+
+type WebhookModelInterface interface {
+	Insert(ctx context.Context, userID int, url string, events []string) (Webhook, error)
+	Get(ctx context.Context, userID, id int) (Webhook, error)
+	GetForUser(ctx context.Context, userID int) ([]Webhook, error)
+	Delete(ctx context.Context, userID, id int) error
+	Enqueue(ctx context.Context, userID int, event string, payload []byte) (int, error)
+	EnqueueOne(ctx context.Context, webhookID int, event string, payload []byte) error
+	Deliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, id int, attempt WebhookAttempt) error
+}
+
+// A Webhook is a URL which is sent a signed POST request whenever one of the
+// events it subscribes to happens to one of its owner's snippets.
+type Webhook struct {
+	ID      int
+	UserID  int
+	URL     string
+	Secret  string // used to sign the payloads with HMAC-SHA256
+	Events  []string
+	Created time.Time
+}
+
+// Subscribes reports whether the webhook should be sent the given event.
+func (w Webhook) Subscribes(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+// A WebhookDelivery is one event queued for delivery to a webhook, along with
+// the outcome of the latest attempt to deliver it. The URL and Secret fields
+// are those of the webhook, and are only set by Claim().
+type WebhookDelivery struct {
+	ID           int
+	WebhookID    int
+	URL          string
+	Secret       string
+	Event        string
+	Payload      []byte
+	Status       string
+	Attempts     int
+	Created      time.Time
+	NextAttempt  time.Time
+	LastAttempt  time.Time // zero if there hasn't been an attempt yet
+	ResponseCode int       // zero if no response was received
+	Duration     time.Duration
+	Error        string
+}
+
+// A WebhookAttempt is the outcome of an attempt to deliver a webhook.
+type WebhookAttempt struct {
+	Time         time.Time
+	ResponseCode int
+	Duration     time.Duration
+	Error        string
+	Delivered    bool
+	// When to try again if the attempt failed. The zero time means that
+	// we've given up on the delivery.
+	NextAttempt time.Time
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a webhook for the user with a newly generated secret.
+func (m *WebhookModel) Insert(ctx context.Context, userID int, url string, events []string) (_ Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Insert")
+	defer func() { endSpan(span, err) }()
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	w := Webhook{
+		UserID:  userID,
+		URL:     url,
+		Secret:  hex.EncodeToString(secret),
+		Events:  events,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+
+	stmt := `INSERT INTO webhooks (user_id, url, secret, events, created) VALUES(?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, w.UserID, w.URL, w.Secret, strings.Join(w.Events, ","), w.Created)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	w.ID = int(id)
+
+	return w, nil
+}
+
+// Get returns one of the user's webhooks, or ErrNoRecord if it doesn't exist
+// or belongs to somebody else.
+func (m *WebhookModel) Get(ctx context.Context, userID, id int) (_ Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Get")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, url, secret, events, created FROM webhooks WHERE id = ? AND user_id = ?`
+
+	var (
+		w      Webhook
+		events string
+	)
+
+	err = m.DB.QueryRowContext(ctx, stmt, id, userID).Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, ErrNoRecord
+		}
+		return Webhook{}, err
+	}
+	w.Events = splitEvents(events)
+
+	return w, nil
+}
+
+func (m *WebhookModel) GetForUser(ctx context.Context, userID int) (_ []Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.GetForUser")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, user_id, url, secret, events, created FROM webhooks WHERE user_id = ? ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var (
+			w      Webhook
+			events string
+		)
+		err = rows.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Created)
+		if err != nil {
+			return nil, err
+		}
+		w.Events = splitEvents(events)
+
+		webhooks = append(webhooks, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes one of the user's webhooks, along with its deliveries. It
+// returns ErrNoRecord if the webhook doesn't exist or belongs to somebody
+// else.
+func (m *WebhookModel) Delete(ctx context.Context, userID, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Delete")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `DELETE FROM webhooks WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Enqueue queues the event for delivery to each of the user's webhooks which
+// subscribe to it, and returns how many deliveries were queued.
+func (m *WebhookModel) Enqueue(ctx context.Context, userID int, event string, payload []byte) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Enqueue")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, created, next_attempt)
+	SELECT id, ?, ?, ?, 0, UTC_TIMESTAMP(), UTC_TIMESTAMP() FROM webhooks
+	WHERE user_id = ? AND FIND_IN_SET(?, events) > 0`
+
+	result, err := m.DB.ExecContext(ctx, stmt, event, payload, DeliveryPending, userID, event)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// EnqueueOne queues the event for delivery to a single webhook, whether or
+// not it subscribes to the event.
+func (m *WebhookModel) EnqueueOne(ctx context.Context, webhookID int, event string, payload []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.EnqueueOne")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, created, next_attempt)
+	VALUES(?, ?, ?, ?, 0, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	_, err = m.DB.ExecContext(ctx, stmt, webhookID, event, payload, DeliveryPending)
+	return err
+}
+
+// Deliveries returns the 50 most recent deliveries for a webhook, newest
+// first. The payloads aren't loaded.
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID int) (_ []WebhookDelivery, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Deliveries")
+	defer func() { endSpan(span, err) }()
+
+	stmt := `SELECT id, webhook_id, event, status, attempts, created, next_attempt, last_attempt,
+	response_code, duration_ms, error
+	FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT 50`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var (
+			d            WebhookDelivery
+			lastAttempt  sql.NullTime
+			responseCode sql.NullInt64
+			durationMS   sql.NullInt64
+			errorMessage sql.NullString
+		)
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Status, &d.Attempts, &d.Created, &d.NextAttempt,
+			&lastAttempt, &responseCode, &durationMS, &errorMessage)
+		if err != nil {
+			return nil, err
+		}
+		d.LastAttempt = lastAttempt.Time
+		d.ResponseCode = int(responseCode.Int64)
+		d.Duration = time.Duration(durationMS.Int64) * time.Millisecond
+		d.Error = errorMessage.String
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Claim returns up to limit pending deliveries which are due to be attempted,
+// oldest first. Each one is leased for the given duration by moving its next
+// attempt into the future, so that it isn't claimed again (by this or another
+// instance of the application) while the attempt is in progress. If the
+// attempt is never recorded, because the application crashed for example, the
+// delivery is retried once the lease expires.
+func (m *WebhookModel) Claim(ctx context.Context, limit int, lease time.Duration) (_ []WebhookDelivery, err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.Claim")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED stops concurrent claims from waiting on each other, or
+	// claiming the same rows.
+	stmt := `SELECT d.id, d.webhook_id, w.url, w.secret, d.event, d.payload, d.status, d.attempts, d.created, d.next_attempt
+	FROM webhook_deliveries d INNER JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.status = ? AND d.next_attempt <= UTC_TIMESTAMP()
+	ORDER BY d.next_attempt, d.id LIMIT ?
+	FOR UPDATE OF d SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, stmt, DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.Created, &d.NextAttempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	stmt = `UPDATE webhook_deliveries SET next_attempt = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND) WHERE id = ?`
+
+	for _, d := range deliveries {
+		_, err = tx.ExecContext(ctx, stmt, int(lease.Seconds()), d.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt records the outcome of an attempt to deliver a webhook, and
+// either marks it as delivered or failed, or schedules the next attempt.
+func (m *WebhookModel) RecordAttempt(ctx context.Context, id int, a WebhookAttempt) (err error) {
+	ctx, span := tracer.Start(ctx, "WebhookModel.RecordAttempt")
+	defer func() { endSpan(span, err) }()
+
+	status, nextAttempt := DeliveryPending, a.NextAttempt
+	switch {
+	case a.Delivered:
+		status, nextAttempt = DeliveryDelivered, a.Time
+	case a.NextAttempt.IsZero():
+		status, nextAttempt = DeliveryFailed, a.Time
+	}
+
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, next_attempt = ?,
+	last_attempt = ?, response_code = ?, duration_ms = ?, error = ? WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, status, nextAttempt.UTC(), a.Time.UTC(), nullInt(a.ResponseCode),
+		a.Duration.Milliseconds(), sql.NullString{String: truncate(a.Error, 255), Valid: a.Error != ""}, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func splitEvents(events string) []string {
+	if events == "" {
+		return nil
+	}
+	return strings.Split(events, ",")
+}
+
+// truncate shortens s to at most n bytes, without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.dkimhw.com/internal/assert"
+)
+
+func TestWebhookModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	ctx := context.Background()
+	m := WebhookModel{newTestDB(t)}
+
+	webhook, err := m.Insert(ctx, 1, "https://example.com/hook", []string{EventSnippetCreated, EventSnippetDeleted})
+	assert.NilError(t, err)
+	assert.Equal(t, len(webhook.Secret), 64)
+
+	got, err := m.Get(ctx, 1, webhook.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, got.Subscribes(EventSnippetDeleted), true)
+	assert.Equal(t, got.Subscribes(EventSnippetUpdated), false)
+
+	// Other users can't see the webhook.
+	_, err = m.Get(ctx, 2, webhook.ID)
+	assert.Equal(t, err, ErrNoRecord)
+
+	// Only events which the webhook subscribes to are queued.
+	n, err := m.Enqueue(ctx, 1, EventSnippetUpdated, []byte(`{}`))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+
+	n, err = m.Enqueue(ctx, 1, EventSnippetCreated, []byte(`{"id":1}`))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+
+	deliveries, err := m.Claim(ctx, 10, time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), 1)
+	assert.Equal(t, deliveries[0].URL, "https://example.com/hook")
+	assert.Equal(t, string(deliveries[0].Payload), `{"id":1}`)
+
+	// The delivery is leased, so it can't be claimed again straight away.
+	claimed, err := m.Claim(ctx, 10, time.Minute)
+	assert.NilError(t, err)
+	assert.Equal(t, len(claimed), 0)
+
+	err = m.RecordAttempt(ctx, deliveries[0].ID, WebhookAttempt{
+		Time:         time.Now(),
+		ResponseCode: 204,
+		Duration:     150 * time.Millisecond,
+		Delivered:    true,
+	})
+	assert.NilError(t, err)
+
+	log, err := m.Deliveries(ctx, webhook.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(log), 1)
+	assert.Equal(t, log[0].Status, DeliveryDelivered)
+	assert.Equal(t, log[0].Attempts, 1)
+	assert.Equal(t, log[0].ResponseCode, 204)
+	assert.Equal(t, log[0].Duration, 150*time.Millisecond)
+
+	err = m.Delete(ctx, 1, webhook.ID)
+	assert.NilError(t, err)
+
+	err = m.Delete(ctx, 1, webhook.ID)
+	assert.Equal(t, err, ErrNoRecord)
+}
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
 	"strings"
@@ -14,6 +15,14 @@ import (
 // variable is more performant than re-parsing the pattern each time we need it.
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// TagRX matches a snippet tag: lower case letters, digits and hyphens, starting
+// with a letter or digit.
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// FileNameRX matches the name of a file in a snippet, which can't contain
+// slashes or other characters which are awkward in archives and URLs.
+var FileNameRX = regexp.MustCompile(`^[A-Za-z0-9._+-][A-Za-z0-9 ._+-]*$`)
+
 type Validator struct {
 	NoneFieldErrors []string // add errors unrelated to specific form fields
 	FieldErrors     map[string]string
@@ -59,6 +68,12 @@ func MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
 }
 
+// Returns true if a value is no more than n bytes long, for values which are
+// limited by the size of a database column rather than by what the user sees.
+func MaxBytes(value string, n int) bool {
+	return len(value) <= n
+}
+
 // generic
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
@@ -73,3 +88,19 @@ func MinChars(value string, n int) bool {
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// Returns true if a value is an absolute http or https URL.
+func WebURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Returns true if every value is one of the permitted values.
+func PermittedValues[T comparable](values []T, permittedValues ...T) bool {
+	for _, value := range values {
+		if !slices.Contains(permittedValues, value) {
+			return false
+		}
+	}
+	return true
+}